@@ -0,0 +1,176 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.uber.org/zap"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/clastix/kubectl-login/internal/actions"
+)
+
+const (
+	caDiscoveryFlag = "k8s-ca-discovery"
+	caCertHashFlag  = "k8s-ca-cert-hash"
+	// Only the SHA-256 digest of the Subject Public Key Info is supported, as by kubeadm
+	caCertHashPrefix = "sha256:"
+)
+
+// discoverCertificateAuthority fetches the cluster certificate authority, keeping the pinned or confirmed certificates.
+func discoverCertificateAuthority(cluster *clientcmdapi.Cluster, hashes []string) ([]byte, error) {
+	for _, h := range hashes {
+		if !strings.HasPrefix(h, caCertHashPrefix) || len(h) != len(caCertHashPrefix)+hex.EncodedLen(sha256.Size) {
+			return nil, fmt.Errorf("invalid --%s %q, expected the %s<hex encoded digest> format", caCertHashFlag, h, caCertHashPrefix)
+		}
+	}
+
+	// The certificate authority is unknown yet: it's verified against the pins, or the user, once downloaded
+	insecure := cluster.DeepCopy()
+	insecure.InsecureSkipTLSVerify, insecure.CertificateAuthorityData = true, nil
+	logger.Info("Fetching the certificate authority from the cluster-info ConfigMap", zap.String("server", cluster.Server))
+	ca, err := fetchClusterInfo(insecure)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	if certs, err = parseCertificates(ca); err != nil {
+		return nil, err
+	}
+
+	var trusted []*x509.Certificate
+	if len(hashes) > 0 {
+		trusted = pinnedCertificates(certs, hashes)
+		if len(trusted) == 0 {
+			return nil, fmt.Errorf("the certificate authority published by the Kubernetes API server doesn't match any --%s, found %s", caCertHashFlag, strings.Join(caCertHashes(certs), ", "))
+		}
+	} else if trusted, err = confirmCertificates(cluster.Server, certs); err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	for _, c := range trusted {
+		_ = pem.Encode(&b, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})
+	}
+
+	// As kubeadm does, the ConfigMap must be served the same once the connection is verified with the trusted certificates
+	secure := cluster.DeepCopy()
+	secure.InsecureSkipTLSVerify, secure.CertificateAuthorityData = false, b.Bytes()
+	var verified []byte
+	if verified, err = fetchClusterInfo(secure); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(ca, verified) {
+		return nil, fmt.Errorf("the certificate authority published by the Kubernetes API server has changed once the connection is verified")
+	}
+
+	return b.Bytes(), nil
+}
+
+func fetchClusterInfo(cluster *clientcmdapi.Cluster) ([]byte, error) {
+	config, err := clusterRestConfig(cluster, "")
+	if err != nil {
+		return nil, err
+	}
+	ca, err := actions.NewClusterInfo(logger, config).Handle()
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch the Kubernetes API server certificate authority (%w)", err)
+	}
+	return ca, nil
+}
+
+// pinnedCertificates returns the certificates matching any of the given hashes.
+func pinnedCertificates(certs []*x509.Certificate, hashes []string) (pinned []*x509.Certificate) {
+	for _, c := range certs {
+		for _, h := range hashes {
+			if strings.EqualFold(h, caCertHash(c)) {
+				logger.Info("The certificate authority matches the pinned hash", zap.String("hash", h))
+				pinned = append(pinned, c)
+				break
+			}
+		}
+	}
+	return
+}
+
+// confirmCertificates asks the user to trust each certificate of the certificate authority.
+func confirmCertificates(server string, certs []*x509.Certificate) (trusted []*x509.Certificate, err error) {
+	hint := fmt.Sprintf("verify it with the cluster administrator and pin it with --%s=%s", caCertHashFlag, caCertHash(certs[0]))
+	if !interactive {
+		return nil, fmt.Errorf("the certificate authority of %s cannot be confirmed without a terminal, %s", server, hint)
+	}
+
+	fmt.Println("")
+	fmt.Printf("The Kubernetes API server %s publishes the following certificate authority.\n", server)
+	fmt.Println("Its authenticity cannot be verified: compare the hashes with the ones provided by the cluster administrator.")
+
+	in := bufio.NewReader(os.Stdin)
+	for _, c := range certs {
+		fmt.Println("")
+		fmt.Printf("  Subject: %s\n", c.Subject)
+		fmt.Printf("  Expiration: %s\n", c.NotAfter.UTC().Format("2006-01-02T15:04:05Z"))
+		fmt.Printf("  Hash: %s\n", caCertHash(c))
+		fmt.Print("Trust it? [y/N]: ")
+
+		answer, _ := in.ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a == "y" || a == "yes" {
+			trusted = append(trusted, c)
+		}
+	}
+	if len(trusted) == 0 {
+		return nil, fmt.Errorf("the certificate authority has not been trusted, %s", hint)
+	}
+	return trusted, nil
+}
+
+func parseCertificates(b []byte) (certs []*x509.Certificate, err error) {
+	for block, rest := pem.Decode(b); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		var c *x509.Certificate
+		if c, err = x509.ParseCertificate(block.Bytes); err != nil {
+			return nil, fmt.Errorf("cannot parse the certificate authority (%w)", err)
+		}
+		certs = append(certs, c)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("the certificate authority contains no PEM encoded certificate")
+	}
+	return certs, nil
+}
+
+// caCertHash returns the public key pin of the given certificate, as printed by kubeadm token create --print-join-command.
+func caCertHash(c *x509.Certificate) string {
+	sum := sha256.Sum256(c.RawSubjectPublicKeyInfo)
+	return caCertHashPrefix + hex.EncodeToString(sum[:])
+}
+
+func caCertHashes(certs []*x509.Certificate) (hashes []string) {
+	for _, c := range certs {
+		hashes = append(hashes, caCertHash(c))
+	}
+	return
+}
@@ -0,0 +1,116 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// newClusterInfoServer serves the kube-public/cluster-info ConfigMap publishing its own certificate, as kubeadm does.
+func newClusterInfoServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewUnstartedServer(nil)
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/kube-public/configmaps/cluster-info" {
+			http.NotFound(w, r)
+			return
+		}
+		cfg := clientcmdapi.NewConfig()
+		cfg.Clusters[""] = &clientcmdapi.Cluster{
+			Server:                   srv.URL,
+			CertificateAuthorityData: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}),
+		}
+		b, err := clientcmd.Write(*cfg)
+		if err != nil {
+			t.Error(err)
+		}
+		_ = json.NewEncoder(w).Encode(&corev1.ConfigMap{Data: map[string]string{"kubeconfig": string(b)}})
+	})
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCACertHash(t *testing.T) {
+	srv := newClusterInfoServer(t)
+	c := srv.Certificate()
+
+	// kubeadm hashes the DER encoded Subject Public Key Info
+	der, err := x509.MarshalPKIXPublicKey(c.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(der)
+	expected := "sha256:" + hex.EncodeToString(sum[:])
+
+	if h := caCertHash(c); h != expected {
+		t.Errorf("expected %s, got %s", expected, h)
+	}
+}
+
+func TestDiscoverCertificateAuthority(t *testing.T) {
+	srv := newClusterInfoServer(t)
+	pin := caCertHash(srv.Certificate())
+	other := "sha256:" + strings.Repeat("0", hex.EncodedLen(sha256.Size))
+
+	testCases := map[string]struct {
+		hashes []string
+		err    string
+	}{
+		"matching pin":           {hashes: []string{pin}},
+		"uppercase pin":          {hashes: []string{"sha256:" + strings.ToUpper(strings.TrimPrefix(pin, "sha256:"))}},
+		"one of the pins":        {hashes: []string{other, pin}},
+		"mismatched pin":         {hashes: []string{other}, err: "doesn't match any"},
+		"malformed prefix":       {hashes: []string{"sha1:" + strings.TrimPrefix(pin, "sha256:")}, err: "invalid --k8s-ca-cert-hash"},
+		"missing prefix":         {hashes: []string{strings.TrimPrefix(pin, "sha256:")}, err: "invalid --k8s-ca-cert-hash"},
+		"truncated digest":       {hashes: []string{pin[:len(pin)-2]}, err: "invalid --k8s-ca-cert-hash"},
+		"malformed among pinned": {hashes: []string{pin, "sha256:"}, err: "invalid --k8s-ca-cert-hash"},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ca, err := discoverCertificateAuthority(&clientcmdapi.Cluster{Server: srv.URL}, tc.hashes)
+			if len(tc.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected the error %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			certs, err := parseCertificates(ca)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(certs) != 1 || !certs[0].Equal(srv.Certificate()) {
+				t.Errorf("expected the server certificate authority to be trusted, got %d certificates", len(certs))
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mitchellh/go-homedir"
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+)
+
+const (
+	configDirName        = "kubectl-login"
+	configFileName       = "config.yaml"
+	legacyConfigFileName = ".kubectl-login.yaml"
+	configFilePerm       = 0600
+)
+
+// xdgDir is an XDG base directory: the environment variable setting it, and its default relative to the home directory.
+type xdgDir struct {
+	env, home string
+}
+
+var (
+	xdgConfigHome = xdgDir{env: "XDG_CONFIG_HOME", home: ".config"}
+	xdgDataHome   = xdgDir{env: "XDG_DATA_HOME", home: filepath.Join(".local", "share")}
+)
+
+// xdgPath returns the given file of the kubectl-login directory in the given XDG base directory.
+func xdgPath(dir xdgDir, name string) (string, error) {
+	base := os.Getenv(dir.env)
+	// Relative paths are invalid according to the specification and must be ignored
+	if len(base) == 0 || !filepath.IsAbs(base) {
+		home, err := homedir.Dir()
+		if err != nil {
+			return "", fmt.Errorf("cannot find the home directory (%w)", err)
+		}
+		base = filepath.Join(home, dir.home)
+	}
+	return filepath.Join(base, configDirName, name), nil
+}
+
+// configFilePath returns $XDG_CONFIG_HOME/kubectl-login/config.yaml, unless the legacy file is still present.
+func configFilePath() (string, error) {
+	p, err := xdgPath(xdgConfigHome, configFileName)
+	if err != nil {
+		return "", err
+	}
+	if fileExists(p) {
+		return p, nil
+	}
+
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", fmt.Errorf("cannot find the home directory (%w)", err)
+	}
+	if legacy := filepath.Join(home, legacyConfigFileName); fileExists(legacy) {
+		return legacy, nil
+	}
+	return p, nil
+}
+
+// writeConfigFile saves the given settings to the configuration file, readable by the owner only.
+func writeConfigFile(v *viper.Viper) error {
+	p := v.ConfigFileUsed()
+	if err := ensureFile(p); err != nil {
+		return fmt.Errorf("cannot create the configuration file (%w)", err)
+	}
+	// The files created by the previous versions are readable by everyone
+	if err := os.Chmod(p, configFilePerm); err != nil {
+		return fmt.Errorf("cannot restrict the configuration file permissions (%w)", err)
+	}
+	if err := v.WriteConfig(); err != nil {
+		return fmt.Errorf("cannot write the configuration file %s (%w)", p, err)
+	}
+	return nil
+}
+
+// ensureFile creates the given file when missing, empty and readable by the owner only, along with its directory.
+func ensureFile(p string) error {
+	if fileExists(p) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, configFilePerm)
+	if os.IsExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func fileExists(p string) bool {
+	ok, _ := afero.Exists(afero.NewOsFs(), p)
+	return ok
+}
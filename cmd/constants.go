@@ -22,30 +22,123 @@ const (
 	KubeconfigPath              = "kubernetes.kubeconfig"
 	K8SSkipTLSVerify            = "kubernetes.ca.insecure"
 	K8SCertificateAuthorityPath = "kubernetes.ca.path"
+	K8SCertificateAuthorityData = "kubernetes.ca.data"
+	K8STLSServerName            = "kubernetes.tlsservername"
+	K8SProxyURL                 = "kubernetes.proxyurl"
+	K8STimeoutDuration          = "kubernetes.timeout"
+	K8SExecAPIVersion           = "kubernetes.exec.apiversion"
+	K8SClusterName              = "kubernetes.names.cluster"
+	K8SContextName              = "kubernetes.names.context"
+	K8SUserName                 = "kubernetes.names.user"
+	KubeconfigBackups           = "kubernetes.backups"
+	// Kubeconfig entries generated upon login, removed by the logout
+	KubeconfigGenerated       = "kubernetes.generated"
+	KubeconfigGeneratedPath   = "kubernetes.generated.path"
+	KubeconfigCluster         = "kubernetes.generated.cluster"
+	KubeconfigContext         = "kubernetes.generated.context"
+	KubeconfigUser            = "kubernetes.generated.user"
+	KubeconfigPreviousContext = "kubernetes.generated.previouscontext"
 	// OIDC viper keys
 	OIDCServer               = "oidc.server"
 	OIDCClientID             = "oidc.clientid"
+	OIDCClientSecret         = "oidc.clientsecret" // Written only by the previous versions, moved to the token store
+	OIDCClientAuthMethod     = "oidc.clientauth.method"
+	OIDCClientPrivateKey     = "oidc.clientauth.key"
+	OIDCClientKeyID          = "oidc.clientauth.kid"
 	OIDCTimeoutDuration      = "oidc.timeout"
 	OIDCSkipTLSVerify        = "oidc.ca.insecure"
 	OIDCCertificateAuthority = "oidc.ca.path"
-	// Token viper keys
-	TokenID       = "token.id"
-	TokenRefresh  = "token.refresh"
-	TokenEndpoint = "token.endpoint"
+	OIDCClientCertificate    = "oidc.tls.cert"
+	OIDCClientKey            = "oidc.tls.key"
+	OIDCProxyURL             = "oidc.proxy.url"
+	OIDCNoProxy              = "oidc.proxy.noproxy"
+	OIDCHeaders              = "oidc.headers"
+	OIDCMaxRetries           = "oidc.retries"
+	OIDCClockSkew            = "oidc.clockskew"
+	TokenRefreshWindow       = "oidc.refreshwindow"
+	OIDCRedirectPort         = "oidc.redirect.port"
+	OIDCRedirectOOB          = "oidc.redirect.oob"
+	OIDCGrantType            = "oidc.grant"
+	OIDCDeviceQRCode         = "oidc.device.qrcode"
+	OIDCUsername             = "oidc.username"
+	OIDCPasswordFile         = "oidc.password.file"
+	// Token store viper keys
+	TokenStoreBackend = "tokenstore.backend"
+	TokenStorePath    = "tokenstore.path"
+	TokenStoreKeyFile = "tokenstore.keyfile"
+	// Token viper keys, the ID and refresh tokens are kept only by the configuration files of the previous versions
+	Token           = "token"
+	TokenID         = "token.id"
+	TokenRefresh    = "token.refresh"
+	TokenEndpoint   = "token.endpoint"
+	TokenAuthMethod = "token.authmethod"
+	TokenIssuer     = "token.issuer"
+	TokenJWKSURI    = "token.jwks"
 )
 
+// Supported grant types
+const (
+	GrantTypeAuthorizationCode = "authorization-code"
+	GrantTypeDeviceCode        = "device-code"
+	GrantTypePassword          = "password"
+	GrantTypeClientCredentials = "client-credentials"
+)
+
+// Environment variables holding the Resource Owner credentials
+const (
+	UsernameEnv = "KUBECTL_LOGIN_USERNAME"
+	PasswordEnv = "KUBECTL_LOGIN_PASSWORD"
+)
+
+// Environment variable holding the OIDC client secret, otherwise kept by the token store
+const ClientSecretEnv = "KUBECTL_LOGIN_CLIENT_SECRET"
+
+// Environment variable holding the passphrase of the encrypted token store
+const TokenStorePassphraseEnv = "KUBECTL_LOGIN_TOKEN_PASSPHRASE"
+
 var (
 	flagsMap = map[string]string{
 		// OIDC flags
 		OIDCServer:               "oidc-server",
 		OIDCClientID:             "oidc-client-id",
+		OIDCClientSecret:         "oidc-client-secret",
+		OIDCClientAuthMethod:     "oidc-client-auth-method",
+		OIDCClientPrivateKey:     "oidc-client-private-key-path",
+		OIDCClientKeyID:          "oidc-client-key-id",
 		OIDCTimeoutDuration:      "oidc-client-timeout",
 		OIDCSkipTLSVerify:        "oidc-insecure-skip-tls-verify",
 		OIDCCertificateAuthority: "oidc-server-ca-path",
+		OIDCClientCertificate:    "oidc-client-cert",
+		OIDCClientKey:            "oidc-client-key",
+		OIDCProxyURL:             "oidc-proxy-url",
+		OIDCNoProxy:              "oidc-no-proxy",
+		OIDCHeaders:              "oidc-header",
+		OIDCMaxRetries:           "oidc-max-retries",
+		OIDCClockSkew:            "oidc-clock-skew",
+		TokenRefreshWindow:       "refresh-window",
+		OIDCRedirectPort:         "oidc-redirect-port",
+		OIDCRedirectOOB:          "oidc-redirect-oob",
+		OIDCGrantType:            "grant-type",
+		OIDCDeviceQRCode:         "oidc-device-qr-code",
+		OIDCUsername:             "oidc-username",
+		OIDCPasswordFile:         "oidc-password-file",
+		// Token store flags
+		TokenStoreBackend: "token-store",
+		TokenStorePath:    "token-store-path",
+		TokenStoreKeyFile: "token-store-key-file",
 		// Kubernetes flags
 		K8SAPIServer:                "k8s-api-server",
 		K8SSkipTLSVerify:            "k8s-insecure-skip-tls-verify",
 		K8SCertificateAuthorityPath: "k8s-server-ca-path",
+		K8SCertificateAuthorityData: "k8s-server-ca-data",
+		K8STLSServerName:            "k8s-tls-server-name",
+		K8SProxyURL:                 "k8s-proxy-url",
+		K8STimeoutDuration:          "k8s-client-timeout",
 		KubeconfigPath:              "kubeconfig-path",
+		K8SExecAPIVersion:           "k8s-exec-api-version",
+		K8SClusterName:              "k8s-cluster-name",
+		K8SContextName:              "k8s-context-name",
+		K8SUserName:                 "k8s-user-name",
+		KubeconfigBackups:           "kubeconfig-backups",
 	}
 )
@@ -0,0 +1,156 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	encodingjson "encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer/json"
+	clientauthenticationv1 "k8s.io/client-go/pkg/apis/clientauthentication/v1"
+	clientauthenticationv1beta1 "k8s.io/client-go/pkg/apis/clientauthentication/v1beta1"
+)
+
+const (
+	execInfoEnv           = "KUBERNETES_EXEC_INFO"
+	execAPIVersionV1      = "client.authentication.k8s.io/v1"
+	execAPIVersionV1beta1 = "client.authentication.k8s.io/v1beta1"
+	// The client.authentication.k8s.io/v1 API has been introduced with kubectl 1.22
+	execV1MinorVersion = 22
+)
+
+// execInfo is the ExecCredential passed by kubectl to the plugin in the KUBERNETES_EXEC_INFO environment variable.
+type execInfo struct {
+	APIVersion  string
+	Interactive bool
+}
+
+// readExecInfo returns the ExecCredential passed by kubectl, defaulting to v1beta1 for the older versions.
+func readExecInfo() (*execInfo, error) {
+	v, ok := os.LookupEnv(execInfoEnv)
+	if !ok || len(v) == 0 {
+		return &execInfo{
+			APIVersion:  execAPIVersionV1beta1,
+			Interactive: interactive,
+		}, nil
+	}
+
+	ec := &struct {
+		metav1.TypeMeta `json:",inline"`
+		Spec            struct {
+			Interactive bool `json:"interactive"`
+		} `json:"spec"`
+	}{}
+	if err := encodingjson.Unmarshal([]byte(v), ec); err != nil {
+		return nil, fmt.Errorf("cannot decode %s (%w)", execInfoEnv, err)
+	}
+
+	switch ec.APIVersion {
+	case execAPIVersionV1, execAPIVersionV1beta1:
+	default:
+		return nil, fmt.Errorf("unsupported ExecCredential API version %s", ec.APIVersion)
+	}
+
+	return &execInfo{
+		APIVersion:  ec.APIVersion,
+		Interactive: ec.Spec.Interactive,
+	}, nil
+}
+
+// encodeExecCredential returns the ExecCredential carrying the given token and its expiration.
+func encodeExecCredential(apiVersion, token string, expiration time.Time) (string, error) {
+	var ec runtime.Object
+	switch apiVersion {
+	case execAPIVersionV1:
+		ec = &clientauthenticationv1.ExecCredential{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "ExecCredential",
+				APIVersion: execAPIVersionV1,
+			},
+			Status: &clientauthenticationv1.ExecCredentialStatus{
+				Token:               token,
+				ExpirationTimestamp: &metav1.Time{Time: expiration},
+			},
+		}
+	default:
+		ec = &clientauthenticationv1beta1.ExecCredential{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "ExecCredential",
+				APIVersion: execAPIVersionV1beta1,
+			},
+			Status: &clientauthenticationv1beta1.ExecCredentialStatus{
+				Token:               token,
+				ExpirationTimestamp: &metav1.Time{Time: expiration},
+			},
+		}
+	}
+
+	scheme := runtime.NewScheme()
+	encoder := json.NewSerializerWithOptions(json.SimpleMetaFactory{}, scheme, scheme, json.SerializerOptions{})
+	a := bytes.NewBuffer([]byte{})
+	if err := encoder.Encode(ec, a); err != nil {
+		return "", fmt.Errorf("cannot encode kubeconfig to JSON (%w)", err)
+	}
+
+	return a.String(), nil
+}
+
+// kubeconfigExecAPIVersion returns the ExecCredential API version supported by the installed kubectl, unless forced.
+func kubeconfigExecAPIVersion() string {
+	switch v := viper.GetString(key(K8SExecAPIVersion)); v {
+	case "v1", execAPIVersionV1:
+		return execAPIVersionV1
+	case "v1beta1", execAPIVersionV1beta1:
+		return execAPIVersionV1beta1
+	}
+
+	out, err := exec.Command("kubectl", "version", "--client", "-o", "json").Output()
+	if err != nil {
+		logger.Debug("Cannot detect the kubectl version", zap.Error(err))
+		return execAPIVersionV1beta1
+	}
+
+	version := &struct {
+		ClientVersion struct {
+			Major string `json:"major"`
+			Minor string `json:"minor"`
+		} `json:"clientVersion"`
+	}{}
+	if err = encodingjson.Unmarshal(out, version); err != nil {
+		logger.Debug("Cannot decode the kubectl version", zap.Error(err))
+		return execAPIVersionV1beta1
+	}
+
+	// Minor versions of some distributions carry a suffix, e.g. 22+
+	major, _ := strconv.Atoi(version.ClientVersion.Major)
+	minor, _ := strconv.Atoi(strings.TrimRight(version.ClientVersion.Minor, "+"))
+	if major > 1 || (major == 1 && minor >= execV1MinorVersion) {
+		return execAPIVersionV1
+	}
+
+	return execAPIVersionV1beta1
+}
@@ -17,79 +17,247 @@ limitations under the License.
 package cmd
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/runtime/serializer/json"
-	clientauthenticationv1beta1 "k8s.io/client-go/pkg/apis/clientauthentication/v1beta1"
 
 	"github.com/clastix/kubectl-login/internal/actions"
+	"github.com/clastix/kubectl-login/internal/oidc"
+	"github.com/clastix/kubectl-login/internal/store"
+)
+
+const (
+	defaultRefreshWindow = 30 * time.Second
+	// Waiting at most for a concurrent refresh, which could be logging in again in the browser
+	refreshLockTimeout = actions.LoopbackTimeout + time.Minute
 )
 
 var tokenCmd = &cobra.Command{
 	Use:   "get-token",
 	Short: "Return a credential execution required by kubectl with the updated ID token",
 	RunE: func(cmd *cobra.Command, args []string) (err error) {
-		var idToken string
-		if idToken = viper.GetString(TokenID); len(idToken) == 0 {
+		if cmd.Flag(flagsMap[TokenRefreshWindow]).Changed {
+			v, _ := cmd.Flags().GetDuration(flagsMap[TokenRefreshWindow])
+			viper.Set(key(TokenRefreshWindow), v)
+		}
+
+		var info *execInfo
+		if info, err = readExecInfo(); err != nil {
+			return
+		}
+		logger.Debug("Executed by kubectl", zap.String("apiVersion", info.APIVersion), zap.Bool("interactive", info.Interactive))
+		// The token store passphrase and the login prompts are allowed only when kubectl lets the plugin use the terminal
+		interactive = info.Interactive
+
+		var s store.TokenStore
+		if s, err = tokenStore(); err != nil {
+			return
+		}
+		var tokens *store.Tokens
+		if tokens, err = loadTokens(s); err != nil {
+			return
+		}
+		idToken := tokens.ID
+		if len(idToken) == 0 {
 			return fmt.Errorf("the ID Token is not yet configured, please issue the login process first")
 		}
 
-		logger.Info("Decoding the ID token as JWT")
-		claims := &jwt.MapClaims{}
-		parser := jwt.Parser{SkipClaimsValidation: true}
-		if _, _, err = parser.ParseUnverified(idToken, claims); err != nil {
-			return fmt.Errorf("token ID is a non JWT encoded string (%w)", err)
+		var client *oidc.HTTPClient
+		if client, err = oidcClient(); err != nil {
+			return
+		}
+
+		var verifier *oidc.IDTokenVerifier
+		if verifier, err = idTokenVerifier(client); err != nil {
+			return
 		}
 
-		if err = claims.Valid(); err != nil {
-			logger.Info("proceeding to token refresh")
-			logger.Debug("JWT claim is not valid due to error", zap.Error(err))
+		window := viper.GetDuration(key(TokenRefreshWindow))
 
-			var refreshToken string
-			idToken, refreshToken, err = actions.NewRefreshToken(logger, true, viper.GetString(TokenEndpoint), viper.GetString(OIDCClientID), viper.GetString(TokenRefresh)).Handle()
-			if err != nil {
-				return fmt.Errorf("cannot refresh token due to an error (%w)", err)
+		var claims jwt.MapClaims
+		var expiration time.Time
+		var stale bool
+		if claims, expiration, stale, err = checkIDToken(verifier, idToken, window); err != nil {
+			return
+		}
+
+		if stale {
+			current, valid := idToken, expiration
+			tokens, err = refreshTokens(s, profile, func(t *store.Tokens) (ok bool, err error) {
+				// The tokens could have been refreshed by another execution while waiting for the lock
+				claims, expiration, ok, err = checkIDToken(verifier, t.ID, window)
+				return
+			}, func(t *store.Tokens) (*store.Tokens, error) {
+				logger.Info("proceeding to token refresh", zap.Time("expiration", expiration), zap.Duration("window", window))
+				return refreshIDToken(client, verifier, t, &claims)
+			})
+			switch {
+			case err != nil && time.Now().Before(valid):
+				// Refreshing within the window is ahead of time, the current token is still accepted until it expires
+				logger.Warn("Cannot refresh the ID token, using the current one", zap.Time("expiration", valid), zap.Error(err))
+				_, _ = fmt.Fprintf(os.Stderr, "Cannot refresh the ID token of the profile %s, the current one expires at %s: %s\n", profile, valid.Format(time.RFC3339), err)
+				idToken, expiration, err = current, valid, nil
+			case err != nil:
+				return
+			default:
+				idToken = tokens.ID
+				expiration, _ = oidc.ExpirationTime(claims)
 			}
+		}
+		var out string
+		if out, err = encodeExecCredential(info.APIVersion, idToken, expiration); err != nil {
+			return
+		}
+
+		fmt.Println(out)
+
+		return nil
+	},
+}
 
-			viper.Set(TokenID, idToken)
-			viper.Set(TokenRefresh, refreshToken)
+// checkIDToken validates the stored ID token, reporting it as stale when expiring within the given window.
+func checkIDToken(verifier *oidc.IDTokenVerifier, idToken string, window time.Duration) (claims jwt.MapClaims, expiration time.Time, stale bool, err error) {
+	logger.Info("Validating the ID token")
 
-			defer func() {
-				if err = viper.WriteConfig(); err != nil {
-					logger.Error("Cannot write configuration file", zap.Error(err))
-				}
-			}()
+	claims, err = verifier.Verify(idToken)
+	if err != nil {
+		var expired *oidc.IDTokenExpiredError
+		if !errors.As(err, &expired) {
+			return nil, time.Time{}, false, fmt.Errorf("the stored ID token cannot be trusted, please issue the login process again (%w)", err)
 		}
-		ec := &clientauthenticationv1beta1.ExecCredential{
-			TypeMeta: metav1.TypeMeta{
-				Kind:       "ExecCredential",
-				APIVersion: "client.authentication.k8s.io/v1beta1",
-			},
-			Status: &clientauthenticationv1beta1.ExecCredentialStatus{
-				Token: viper.GetString(TokenID),
-			},
+		logger.Debug("JWT claim is not valid due to error", zap.Error(err))
+		stale = true
+	}
+
+	expiration, _ = oidc.ExpirationTime(claims)
+	stale = stale || time.Until(expiration) < window
+
+	return claims, expiration, stale, nil
+}
+
+// refreshIDToken issues a new ID token, logging in again when the refresh token is rejected.
+func refreshIDToken(client *oidc.HTTPClient, verifier *oidc.IDTokenVerifier, tokens *store.Tokens, claims *jwt.MapClaims) (*store.Tokens, error) {
+	auth, err := clientAuthentication(viper.GetString(key(TokenAuthMethod)))
+	if err != nil {
+		return nil, err
+	}
+
+	refreshed := &store.Tokens{}
+	switch {
+	case viper.GetString(key(OIDCGrantType)) == GrantTypeClientCredentials:
+		// Machine identities are not issued refresh tokens: requesting a brand new one
+		refreshed.ID, err = actions.NewClientCredentials(logger, viper.GetString(key(TokenEndpoint)), auth, client).Handle()
+	case len(tokens.Refresh) == 0:
+		// The OIDC server didn't issue a refresh token, e.g. without the offline_access scope: only a new login helps
+		if !interactive {
+			return nil, fmt.Errorf("the OIDC server didn't issue a refresh token, please login again with: kubectl login --profile=%s", profile)
 		}
+		return relogin(client, claims)
+	default:
+		refreshed.ID, refreshed.Refresh, err = actions.NewRefreshToken(logger, viper.GetString(key(TokenEndpoint)), tokens.Refresh, auth, client).Handle()
+	}
 
-		scheme := runtime.NewScheme()
-		encoder := json.NewSerializerWithOptions(json.SimpleMetaFactory{}, scheme, scheme, json.SerializerOptions{})
-		a := bytes.NewBuffer([]byte{})
-		if err = encoder.Encode(ec, a); err != nil {
-			return fmt.Errorf("cannot encode kubeconfig to JSON (%w)", err)
+	switch {
+	case oidc.IsOAuthError(err, oidc.ErrorInvalidGrant):
+		// The refresh token has expired or has been revoked: only a new login can issue a valid one
+		if !interactive {
+			return nil, fmt.Errorf("the refresh token has expired or has been revoked, please login again with: kubectl login --profile=%s (%w)", profile, err)
 		}
+		return relogin(client, claims)
+	case err != nil:
+		return nil, fmt.Errorf("cannot refresh token due to an error (%w)", err)
+	}
 
-		fmt.Println(a.String())
+	if *claims, err = verifier.Verify(refreshed.ID); err != nil {
+		return nil, fmt.Errorf("cannot validate the refreshed ID token (%w)", err)
+	}
+	return refreshed, nil
+}
 
-		return nil
-	},
+// refreshTokens refreshes the tokens of the given profile holding its lock, unless refreshed meanwhile.
+func refreshTokens(s store.TokenStore, name string, stale func(*store.Tokens) (bool, error), refresh func(*store.Tokens) (*store.Tokens, error)) (*store.Tokens, error) {
+	unlock, err := lockProfile(name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	tokens, err := s.Load(name)
+	if err != nil {
+		return nil, err
+	}
+	var ok bool
+	if ok, err = stale(tokens); err != nil || !ok {
+		return tokens, err
+	}
+
+	var refreshed *store.Tokens
+	if refreshed, err = refresh(tokens); err != nil {
+		return nil, err
+	}
+	// The client secret is not issued by the OIDC server, neither the refresh token when not rotated
+	if len(refreshed.ClientSecret) == 0 {
+		refreshed.ClientSecret = tokens.ClientSecret
+	}
+	if len(refreshed.Refresh) == 0 {
+		refreshed.Refresh = tokens.Refresh
+	}
+	tokens = refreshed
+	if err = s.Save(name, tokens); err != nil {
+		return nil, fmt.Errorf("cannot save the refreshed tokens (%w)", err)
+	}
+	return tokens, nil
+}
+
+// relogin performs the configured login flow, printing the instructions on the standard error.
+func relogin(client *oidc.HTTPClient, claims *jwt.MapClaims) (*store.Tokens, error) {
+	logger.Info("The refresh token cannot be used, logging in again")
+	_, _ = fmt.Fprintf(os.Stderr, "The session of the profile %s has expired, please login again\n", profile)
+
+	promptOutput = os.Stderr
+	token, refresh, c, err := authenticate(client)
+	if err != nil {
+		return nil, fmt.Errorf("cannot login again (%w)", err)
+	}
+	*claims = c
+	// The OIDC server configuration could have changed since the previous login
+	if err = writeConfigFile(viper.GetViper()); err != nil {
+		logger.Error("Cannot write configuration file", zap.Error(err))
+	}
+
+	return &store.Tokens{ID: token, Refresh: refresh}, nil
+}
+
+// profileLockPath returns the file locking the tokens of the given profile.
+func profileLockPath(name string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "kubectl-login", fmt.Sprintf("refresh-%s.lock", name))
+}
+
+// lockProfile serializes the changes of the given profile tokens among the concurrent executions.
+func lockProfile(name string) (func(), error) {
+	ctx, cancel := context.WithTimeout(context.Background(), refreshLockTimeout)
+	defer cancel()
+
+	unlock, err := store.Lock(ctx, profileLockPath(name))
+	if err != nil {
+		return nil, fmt.Errorf("cannot lock the tokens of the profile %s (%w)", name, err)
+	}
+	return unlock, nil
 }
 
 func init() {
 	rootCmd.AddCommand(tokenCmd)
+	tokenCmd.Flags().Duration(flagsMap[TokenRefreshWindow], defaultRefreshWindow, "Refresh the ID token when expiring within the given duration")
 }
@@ -0,0 +1,205 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/spf13/viper"
+
+	"github.com/clastix/kubectl-login/internal/actions"
+	"github.com/clastix/kubectl-login/internal/oidc"
+	"github.com/clastix/kubectl-login/internal/store"
+)
+
+const staleIDToken = "stale"
+
+// rotatingTokenEndpoint issues a new refresh token upon each refresh, rejecting the redeemed ones as the OIDC servers
+// enforcing the refresh token rotation do, otherwise the refresh tokens are kept valid and not issued again.
+type rotatingTokenEndpoint struct {
+	mu     sync.Mutex
+	rotate bool
+	valid  map[string]bool
+	issued int
+	hits   int
+}
+
+func newRotatingTokenEndpoint(rotate bool, refreshTokens ...string) *rotatingTokenEndpoint {
+	e := &rotatingTokenEndpoint{rotate: rotate, valid: map[string]bool{}}
+	for _, t := range refreshTokens {
+		e.valid[t] = true
+	}
+	return e
+}
+
+func (e *rotatingTokenEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.hits++
+	w.Header().Set("Content-Type", "application/json")
+	t := r.PostFormValue("refresh_token")
+	if !e.valid[t] {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": oidc.ErrorInvalidGrant})
+		return
+	}
+
+	e.issued++
+	res := map[string]string{"id_token": fmt.Sprintf("id-%d", e.issued)}
+	if e.rotate {
+		delete(e.valid, t)
+		res["refresh_token"] = fmt.Sprintf("refresh-%d", e.issued)
+		e.valid[res["refresh_token"]] = true
+	}
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+func (e *rotatingTokenEndpoint) Hits() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.hits
+}
+
+// concurrentRefreshes runs the given number of refreshes of each profile at once, each one with its own store as
+// separate executions would do.
+func concurrentRefreshes(t *testing.T, endpoint, path string, profiles []string, n int) map[string][]*store.Tokens {
+	client := &oidc.HTTPClient{Client: http.Client{}}
+	auth, err := oidc.NewClientAuthentication(oidc.ClientAuthNone, "kubectl", "", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := map[string][]*store.Tokens{}
+	for _, p := range profiles {
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+
+				tokens, err := refreshTokens(store.NewFileStore(path), name, func(tokens *store.Tokens) (bool, error) {
+					return tokens.ID == staleIDToken, nil
+				}, func(tokens *store.Tokens) (*store.Tokens, error) {
+					id, refresh, err := actions.NewRefreshToken(logger, endpoint, tokens.Refresh, auth, client).Handle()
+					if err != nil {
+						return nil, err
+					}
+					return &store.Tokens{ID: id, Refresh: refresh}, nil
+				})
+				if err != nil {
+					t.Errorf("refresh of the profile %s failed: %s", name, err)
+					return
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				results[name] = append(results[name], tokens)
+			}(p)
+		}
+	}
+	wg.Wait()
+
+	return results
+}
+
+func TestRefreshTokensConcurrently(t *testing.T) {
+	testCases := map[string]struct {
+		profiles []string
+		rotate   bool
+	}{
+		"single profile":            {profiles: []string{"default"}, rotate: true},
+		"two profiles":              {profiles: []string{"default", "staging"}, rotate: true},
+		"refresh token not rotated": {profiles: []string{"default"}},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			profiles := tc.profiles
+			t.Setenv("XDG_CACHE_HOME", t.TempDir())
+			path := filepath.Join(t.TempDir(), "tokens.json")
+
+			var refreshTokens []string
+			s := store.NewFileStore(path)
+			for _, p := range profiles {
+				refreshTokens = append(refreshTokens, "refresh-"+p)
+				if err := s.Save(p, &store.Tokens{ID: staleIDToken, Refresh: "refresh-" + p}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			endpoint := newRotatingTokenEndpoint(tc.rotate, refreshTokens...)
+			srv := httptest.NewServer(endpoint)
+			defer srv.Close()
+
+			results := concurrentRefreshes(t, srv.URL, path, profiles, 10)
+
+			if hits := endpoint.Hits(); hits != len(profiles) {
+				t.Errorf("expected %d token requests, got %d", len(profiles), hits)
+			}
+			for _, p := range profiles {
+				stored, err := s.Load(p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if stored.ID == staleIDToken {
+					t.Errorf("the refreshed tokens of the profile %s have been lost", p)
+				}
+				if !tc.rotate && stored.Refresh != "refresh-"+p {
+					t.Errorf("expected the refresh token of the profile %s to be kept, got %q", p, stored.Refresh)
+				}
+				for _, tokens := range results[p] {
+					if *tokens != *stored {
+						t.Errorf("expected the stored tokens %v for the profile %s, got %v", *stored, p, *tokens)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRefreshIDTokenWithoutRefreshToken(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	previous := interactive
+	t.Cleanup(func() { interactive = previous })
+
+	endpoint := newRotatingTokenEndpoint(true)
+	srv := httptest.NewServer(endpoint)
+	defer srv.Close()
+
+	viper.Set(key(OIDCClientID), "kubectl")
+	viper.Set(key(TokenEndpoint), srv.URL)
+	interactive = false
+
+	var claims jwt.MapClaims
+	_, err := refreshIDToken(&oidc.HTTPClient{Client: http.Client{}}, nil, &store.Tokens{ID: staleIDToken}, &claims)
+	if err == nil || !strings.Contains(err.Error(), "didn't issue a refresh token") {
+		t.Errorf("expected the missing refresh token error, got %v", err)
+	}
+	if hits := endpoint.Hits(); hits != 0 {
+		t.Errorf("expected no token request, got %d", hits)
+	}
+}
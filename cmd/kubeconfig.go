@@ -0,0 +1,204 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"text/template"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/spf13/viper"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	defaultEntryName   = "oidc"
+	defaultClusterName = "{{ .Scheme }}_{{ .Host }}_{{ .Port }}"
+	// One-off decisions, not stored in the profile
+	kubeconfigOverwriteFlag = "kubeconfig-overwrite"
+	setCurrentContextFlag   = "set-current-context"
+)
+
+// kubeconfigNames holds the names of the kubeconfig entries generated upon login.
+type kubeconfigNames struct {
+	Cluster, Context, User string
+}
+
+// kubeconfigNameData is the data available to the templates of the kubeconfig entry names.
+type kubeconfigNameData struct {
+	Profile, Scheme, Host, Port string
+	Claims                      map[string]interface{}
+}
+
+// newKubeconfigNames renders the configured names of the kubeconfig entries.
+func newKubeconfigNames(server string, claims jwt.MapClaims) (*kubeconfigNames, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse the Kubernetes API server URL (%w)", err)
+	}
+	data := kubeconfigNameData{
+		Profile: profile,
+		Scheme:  u.Scheme,
+		Host:    u.Hostname(),
+		Port:    u.Port(),
+		Claims:  claims,
+	}
+
+	entry := defaultEntryName
+	if profile != defaultProfile {
+		entry = fmt.Sprintf("%s-%s", defaultEntryName, profile)
+	}
+
+	names := &kubeconfigNames{}
+	for _, n := range []struct {
+		key, fallback string
+		out           *string
+	}{
+		{key: K8SClusterName, fallback: defaultClusterName, out: &names.Cluster},
+		{key: K8SContextName, fallback: entry, out: &names.Context},
+		{key: K8SUserName, fallback: entry, out: &names.User},
+	} {
+		text := viper.GetString(key(n.key))
+		if len(text) == 0 {
+			text = n.fallback
+		}
+		if *n.out, err = renderName(text, data); err != nil {
+			return nil, fmt.Errorf("cannot render the name set with --%s (%w)", flagsMap[n.key], err)
+		}
+	}
+
+	return names, nil
+}
+
+func renderName(text string, data kubeconfigNameData) (string, error) {
+	// Referencing a claim missing from the ID token is an error rather than an unexpected "<no value>"
+	t, err := template.New("name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	b := bytes.NewBuffer([]byte{})
+	if err = t.Execute(b, data); err != nil {
+		return "", err
+	}
+	name := strings.TrimSpace(b.String())
+	if len(name) == 0 {
+		return "", fmt.Errorf("the template %q renders an empty name", text)
+	}
+	return name, nil
+}
+
+// kubeconfigConflicts returns the existing entries that would be replaced with a different configuration.
+func kubeconfigConflicts(cfg *clientcmdapi.Config, names *kubeconfigNames, cluster *clientcmdapi.Cluster, context *clientcmdapi.Context, user *clientcmdapi.AuthInfo) (out []string) {
+	if c, ok := cfg.Clusters[names.Cluster]; ok && c.Server != cluster.Server {
+		out = append(out, fmt.Sprintf("cluster %q points to %s", names.Cluster, c.Server))
+	}
+	if c, ok := cfg.Contexts[names.Context]; ok && (c.Cluster != context.Cluster || c.AuthInfo != context.AuthInfo) {
+		out = append(out, fmt.Sprintf("context %q uses the cluster %q and user %q", names.Context, c.Cluster, c.AuthInfo))
+	}
+	if u, ok := cfg.AuthInfos[names.User]; ok && !samePluginExec(u.Exec, user.Exec) {
+		out = append(out, fmt.Sprintf("user %q has different credentials", names.User))
+	}
+	return
+}
+
+// samePluginExec reports whether the existing exec entry runs this plugin as the generated one: the profile argument,
+// missing from the entries written by the previous versions, is not compared.
+func samePluginExec(existing, generated *clientcmdapi.ExecConfig) bool {
+	if existing == nil {
+		return false
+	}
+	a, ok := pluginArgs(existing)
+	if !ok {
+		return false
+	}
+	b, _ := pluginArgs(generated)
+	return reflect.DeepEqual(a, b)
+}
+
+// pluginArgs returns the arguments of an exec entry running this plugin, either through kubectl or directly, without
+// the profile one.
+func pluginArgs(e *clientcmdapi.ExecConfig) (out []string, ok bool) {
+	args := e.Args
+	switch filepath.Base(e.Command) {
+	case "kubectl":
+		if len(args) == 0 || args[0] != "login" {
+			return nil, false
+		}
+		args = args[1:]
+	case "kubectl-login":
+	default:
+		return nil, false
+	}
+
+	out = []string{}
+	for i := 0; i < len(args); i++ {
+		switch {
+		case strings.HasPrefix(args[i], "--profile="):
+		case args[i] == "--profile":
+			i++
+		default:
+			out = append(out, args[i])
+		}
+	}
+	return out, true
+}
+
+// mergeKubeconfigEntries adds the generated entries to the kubeconfig, replacing the existing ones: the new ones are
+// written to the given file, or the default one when empty.
+func mergeKubeconfigEntries(cfg *clientcmdapi.Config, names *kubeconfigNames, cluster *clientcmdapi.Cluster, context *clientcmdapi.Context, user *clientcmdapi.AuthInfo, destination string) {
+	cluster.LocationOfOrigin, context.LocationOfOrigin, user.LocationOfOrigin = destination, destination, destination
+	if c, ok := cfg.Clusters[names.Cluster]; ok {
+		cluster.LocationOfOrigin = c.LocationOfOrigin
+	}
+	if c, ok := cfg.Contexts[names.Context]; ok {
+		context.LocationOfOrigin = c.LocationOfOrigin
+	}
+	if u, ok := cfg.AuthInfos[names.User]; ok {
+		user.LocationOfOrigin = u.LocationOfOrigin
+	}
+	cfg.Clusters[names.Cluster] = cluster
+	cfg.Contexts[names.Context] = context
+	cfg.AuthInfos[names.User] = user
+}
+
+// confirmKubeconfigOverwrite asks the user whether the conflicting entries can be replaced.
+func confirmKubeconfigOverwrite(conflicts []string) error {
+	hint := fmt.Sprintf("choose different names with --%s, --%s and --%s, or force it with --%s", flagsMap[K8SClusterName], flagsMap[K8SContextName], flagsMap[K8SUserName], kubeconfigOverwriteFlag)
+	if !interactive {
+		return fmt.Errorf("the kubeconfig already contains differently configured entries (%s), %s", strings.Join(conflicts, ", "), hint)
+	}
+
+	fmt.Println("")
+	fmt.Println("The kubeconfig already contains differently configured entries:")
+	for _, c := range conflicts {
+		fmt.Printf("  - %s\n", c)
+	}
+	fmt.Print("Replace them? [y/N]: ")
+
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+		return fmt.Errorf("the kubeconfig has been left untouched, %s", hint)
+	}
+	return nil
+}
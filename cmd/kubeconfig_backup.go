@@ -0,0 +1,192 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	defaultKubeconfigBackups = 10
+	// Timestamps sorting lexicographically in chronological order
+	kubeconfigBackupLayout = "20060102T150405.000Z"
+	kubeconfigBackupInfix  = ".backup-"
+	dryRunFlag             = "dry-run"
+	dryRunDiff             = "diff"
+	dryRunKubeconfig       = "kubeconfig"
+)
+
+// kubeconfigLoadingRules returns the kubeconfig files merged by kubectl, along with the one set with --kubeconfig-path.
+func kubeconfigLoadingRules() *clientcmd.ClientConfigLoadingRules {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if p := viper.GetString(key(KubeconfigPath)); len(p) > 0 {
+		for _, f := range rules.Precedence {
+			if f == p {
+				return rules
+			}
+		}
+		// Unlike the explicit path, a missing file of the precedence list is created upon write
+		rules.Precedence = append(rules.Precedence, p)
+	}
+	return rules
+}
+
+// writeKubeconfig saves the merged kubeconfig, backing up the changed files.
+func writeKubeconfig(rules *clientcmd.ClientConfigLoadingRules, cfg *clientcmdapi.Config) error {
+	backups := make(map[string]string)
+	for _, p := range rules.GetLoadingPrecedence() {
+		b, err := backupKubeconfig(p)
+		if err != nil {
+			return fmt.Errorf("cannot back up the kubeconfig %s, left untouched (%w)", p, err)
+		}
+		if len(b) > 0 {
+			backups[p] = b
+		}
+	}
+
+	// The new entries are written to the default file, unless set otherwise: the directories are accessible by the owner
+	// only when created
+	for _, p := range append(rules.GetLoadingPrecedence(), rules.GetDefaultFilename()) {
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			return fmt.Errorf("cannot create the kubeconfig directory (%w)", err)
+		}
+	}
+	if err := clientcmd.ModifyConfig(rules, *cfg, false); err != nil {
+		return err
+	}
+
+	for p, b := range backups {
+		// The files left unchanged don't need a backup
+		before, _ := afero.ReadFile(afero.NewOsFs(), b)
+		if after, e := afero.ReadFile(afero.NewOsFs(), p); e == nil && bytes.Equal(before, after) {
+			_ = os.Remove(b)
+			continue
+		}
+		logger.Info("Kubeconfig backed up", zap.String("path", b))
+		pruneKubeconfigBackups(p)
+	}
+
+	return nil
+}
+
+// backupKubeconfig copies the kubeconfig next to it with a timestamp suffix, returning the backup path.
+func backupKubeconfig(p string) (string, error) {
+	if viper.GetInt(key(KubeconfigBackups)) <= 0 {
+		return "", nil
+	}
+
+	b, err := afero.ReadFile(afero.NewOsFs(), p)
+	if os.IsNotExist(err) || len(bytes.TrimSpace(b)) == 0 {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	backup := p + kubeconfigBackupInfix + time.Now().UTC().Format(kubeconfigBackupLayout)
+	if err = afero.WriteFile(afero.NewOsFs(), backup, b, 0600); err != nil {
+		return "", err
+	}
+
+	return backup, nil
+}
+
+// pruneKubeconfigBackups removes the oldest backups of the kubeconfig beyond the configured number.
+func pruneKubeconfigBackups(p string) {
+	backups, err := kubeconfigBackups(p)
+	if err != nil {
+		return
+	}
+	for len(backups) > viper.GetInt(key(KubeconfigBackups)) {
+		if e := os.Remove(backups[0]); e != nil {
+			logger.Debug("Cannot remove the kubeconfig backup", zap.String("path", backups[0]), zap.Error(e))
+		}
+		backups = backups[1:]
+	}
+}
+
+// kubeconfigBackups returns the backups of the given kubeconfig, from the oldest to the newest.
+func kubeconfigBackups(p string) ([]string, error) {
+	files, err := ioutil.ReadDir(filepath.Dir(p))
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := filepath.Base(p) + kubeconfigBackupInfix
+	var backups []string
+	for _, f := range files {
+		if f.IsDir() || !strings.HasPrefix(f.Name(), prefix) {
+			continue
+		}
+		if _, e := time.Parse(kubeconfigBackupLayout, strings.TrimPrefix(f.Name(), prefix)); e == nil {
+			backups = append(backups, filepath.Join(filepath.Dir(p), f.Name()))
+		}
+	}
+	sort.Strings(backups)
+
+	return backups, nil
+}
+
+// printKubeconfigDryRun prints the merged kubeconfig that would be written, or the unified diff with the current one.
+func printKubeconfigDryRun(w io.Writer, format string, rules *clientcmd.ClientConfigLoadingRules, before, after *clientcmdapi.Config) error {
+	a, err := clientcmd.Write(*after)
+	if err != nil {
+		return fmt.Errorf("cannot encode the kubeconfig (%w)", err)
+	}
+
+	if format == dryRunKubeconfig {
+		_, err = w.Write(a)
+		return err
+	}
+
+	var b []byte
+	if b, err = clientcmd.Write(*before); err != nil {
+		return fmt.Errorf("cannot encode the kubeconfig (%w)", err)
+	}
+	name := strings.Join(rules.GetLoadingPrecedence(), string(filepath.ListSeparator))
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(b)),
+		B:        difflib.SplitLines(string(a)),
+		FromFile: name,
+		ToFile:   name,
+		Context:  3,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot compute the kubeconfig diff (%w)", err)
+	}
+	if len(diff) == 0 {
+		_, err = fmt.Fprintf(w, "The kubeconfig %s would be left unchanged\n", name)
+		return err
+	}
+	_, err = fmt.Fprint(w, diff)
+	return err
+}
@@ -0,0 +1,119 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// writeTestChange writes the kubeconfig pointing the cluster to the given server, as a login would.
+func writeTestChange(t *testing.T, rules *clientcmd.ClientConfigLoadingRules, server string) {
+	cfg, err := rules.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Clusters["kube"] = &clientcmdapi.Cluster{Server: server}
+	if err = writeKubeconfig(rules, cfg); err != nil {
+		t.Fatal(err)
+	}
+	// The backups are named after the time with a millisecond precision
+	time.Sleep(5 * time.Millisecond)
+}
+
+func backupServers(t *testing.T, p string) (servers []string) {
+	backups, err := kubeconfigBackups(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range backups {
+		servers = append(servers, loadTestKubeconfig(t, b).Clusters["kube"].Server)
+	}
+	return
+}
+
+func TestKubeconfigBackupsAndRestore(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	p := filepath.Join(t.TempDir(), "config")
+	t.Setenv(clientcmd.RecommendedConfigPathEnvVar, p)
+	viper.Set(key(KubeconfigBackups), 3)
+	rules := kubeconfigLoadingRules()
+
+	for i := 0; i < 6; i++ {
+		writeTestChange(t, rules, fmt.Sprintf("https://kube-%d:6443", i))
+	}
+	// The first write has nothing to back up, the oldest of the following five ones are pruned
+	expected := []string{"https://kube-2:6443", "https://kube-3:6443", "https://kube-4:6443"}
+	if servers := backupServers(t, p); fmt.Sprint(servers) != fmt.Sprint(expected) {
+		t.Errorf("expected the backups %v, got %v", expected, servers)
+	}
+
+	writeTestChange(t, rules, "https://kube-5:6443")
+	if servers := backupServers(t, p); fmt.Sprint(servers) != fmt.Sprint(expected) {
+		t.Errorf("expected no backup of an unchanged kubeconfig, got %v", servers)
+	}
+
+	backups, err := kubeconfigBackups(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = restoreCmd.RunE(restoreCmd, []string{backupTimestamp(p, backups[0])}); err != nil {
+		t.Fatal(err)
+	}
+	if s := loadTestKubeconfig(t, p).Clusters["kube"].Server; s != "https://kube-2:6443" {
+		t.Errorf("expected the kubeconfig restored from the chosen backup, got the server %s", s)
+	}
+	// Restoring backs up the current kubeconfig, pruning the oldest backup
+	expected = []string{"https://kube-3:6443", "https://kube-4:6443", "https://kube-5:6443"}
+	if servers := backupServers(t, p); fmt.Sprint(servers) != fmt.Sprint(expected) {
+		t.Errorf("expected the backups %v, got %v", expected, servers)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if err = restoreCmd.RunE(restoreCmd, []string{latestBackup}); err != nil {
+		t.Fatal(err)
+	}
+	if s := loadTestKubeconfig(t, p).Clusters["kube"].Server; s != "https://kube-5:6443" {
+		t.Errorf("expected the kubeconfig restored from the latest backup, got the server %s", s)
+	}
+
+	if err = restoreCmd.RunE(restoreCmd, []string{"19700101T000000.000Z"}); err == nil {
+		t.Errorf("expected an error restoring a missing backup")
+	}
+}
+
+func TestKubeconfigBackupsDisabled(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	p := filepath.Join(t.TempDir(), "config")
+	t.Setenv(clientcmd.RecommendedConfigPathEnvVar, p)
+	viper.Set(key(KubeconfigBackups), 0)
+	rules := kubeconfigLoadingRules()
+
+	writeTestChange(t, rules, "https://kube-0:6443")
+	writeTestChange(t, rules, "https://kube-1:6443")
+	if backups, _ := kubeconfigBackups(p); len(backups) > 0 {
+		t.Errorf("expected no backup, got %v", backups)
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const latestBackup = "latest"
+
+var kubeconfigCmd = &cobra.Command{
+	Use:   "kubeconfig",
+	Short: "Manage the kubeconfig written upon login",
+	// Managing the kubeconfig doesn't require the OIDC server and Kubernetes API server settings
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) (err error) {
+		if err = setupCommand(cmd); err != nil {
+			return
+		}
+		if v, _ := cmd.Flags().GetString(flagsMap[KubeconfigPath]); len(v) > 0 {
+			viper.Set(key(KubeconfigPath), v)
+		}
+		if cmd.Flag(flagsMap[KubeconfigBackups]).Changed {
+			v, _ := cmd.Flags().GetInt(flagsMap[KubeconfigBackups])
+			viper.Set(key(KubeconfigBackups), v)
+		}
+		return nil
+	},
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore [BACKUP]",
+	Short: "Restore the kubeconfig from a backup taken before a change, listing the available ones when none is given",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		files := kubeconfigLoadingRules().GetLoadingPrecedence()
+
+		backups := make(map[string][]string, len(files))
+		found := false
+		for _, p := range files {
+			// The directory of a kubeconfig not yet written could be missing
+			backups[p], _ = kubeconfigBackups(p)
+			found = found || len(backups[p]) > 0
+		}
+		if !found {
+			fmt.Printf("No backup of the kubeconfig %s", strings.Join(files, string(filepath.ListSeparator)))
+			fmt.Println("")
+			return nil
+		}
+
+		if len(args) == 0 {
+			for _, p := range files {
+				if len(backups[p]) == 0 {
+					continue
+				}
+				fmt.Printf("Backups of the kubeconfig %s, from the newest:", p)
+				fmt.Println("")
+				for i := len(backups[p]) - 1; i >= 0; i-- {
+					fmt.Printf("  %s\t%s", backupTimestamp(p, backups[p][i]), backups[p][i])
+					fmt.Println("")
+				}
+				fmt.Println("")
+			}
+			fmt.Printf("Restore one with: kubectl login kubeconfig restore <BACKUP|%s>", latestBackup)
+			fmt.Println("")
+			return nil
+		}
+
+		var p, backup string
+		if p, backup, err = findBackup(files, backups, args[0]); err != nil {
+			return
+		}
+
+		var b []byte
+		if b, err = afero.ReadFile(afero.NewOsFs(), backup); err != nil {
+			return fmt.Errorf("cannot read the kubeconfig backup (%w)", err)
+		}
+		if _, err = clientcmd.Load(b); err != nil {
+			return fmt.Errorf("the backup %s is not a valid kubeconfig (%w)", backup, err)
+		}
+		// Restoring is a change too, that can be rolled back
+		if _, err = backupKubeconfig(p); err != nil {
+			return fmt.Errorf("cannot back up the kubeconfig, left untouched (%w)", err)
+		}
+		if err = afero.WriteFile(afero.NewOsFs(), p, b, 0600); err != nil {
+			return fmt.Errorf("cannot restore the kubeconfig (%w)", err)
+		}
+		pruneKubeconfigBackups(p)
+
+		fmt.Printf("The kubeconfig %s has been restored from %s", p, backup)
+		fmt.Println("")
+
+		return nil
+	},
+}
+
+// findBackup returns the backup matching the given path, file name or timestamp, otherwise the newest one.
+func findBackup(files []string, backups map[string][]string, name string) (p, backup string, err error) {
+	for _, f := range files {
+		for _, b := range backups[f] {
+			switch {
+			case name == latestBackup:
+				if len(backup) == 0 || backupTimestamp(f, b) > backupTimestamp(p, backup) {
+					p, backup = f, b
+				}
+			case name == b, name == filepath.Base(b), name == backupTimestamp(f, b):
+				return f, b, nil
+			}
+		}
+	}
+	if len(backup) == 0 {
+		return "", "", fmt.Errorf("no backup %s of the kubeconfig, run the restore command without arguments to list them", name)
+	}
+	return p, backup, nil
+}
+
+// backupTimestamp returns the timestamp suffix identifying the backup.
+func backupTimestamp(p, backup string) string {
+	return strings.TrimPrefix(filepath.Base(backup), filepath.Base(p)+kubeconfigBackupInfix)
+}
+
+func init() {
+	rootCmd.AddCommand(kubeconfigCmd)
+	kubeconfigCmd.AddCommand(restoreCmd)
+}
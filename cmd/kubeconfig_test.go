@@ -0,0 +1,204 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func writeTestKubeconfig(t *testing.T, p string, cfg *clientcmdapi.Config) {
+	if err := clientcmd.WriteToFile(*cfg, p); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func loadTestKubeconfig(t *testing.T, p string) *clientcmdapi.Config {
+	cfg, err := clientcmd.LoadFromFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestMultipleKubeconfigFiles(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	dir := t.TempDir()
+	missing, first, second := filepath.Join(dir, "missing"), filepath.Join(dir, "first"), filepath.Join(dir, "second")
+	names := &kubeconfigNames{Cluster: "https_kube_6443", Context: "oidc", User: "oidc"}
+
+	// The first file holds an unrelated context, the second one the cluster and user of a previous login
+	cfg := clientcmdapi.NewConfig()
+	cfg.Clusters["work"] = &clientcmdapi.Cluster{Server: "https://work:6443"}
+	cfg.AuthInfos["work"] = &clientcmdapi.AuthInfo{Token: "work"}
+	cfg.Contexts["work"] = &clientcmdapi.Context{Cluster: "work", AuthInfo: "work"}
+	cfg.CurrentContext = "work"
+	writeTestKubeconfig(t, first, cfg)
+
+	cfg = clientcmdapi.NewConfig()
+	cfg.Clusters[names.Cluster] = &clientcmdapi.Cluster{Server: "https://old:6443"}
+	cfg.AuthInfos[names.User] = &clientcmdapi.AuthInfo{Token: "old"}
+	writeTestKubeconfig(t, second, cfg)
+
+	rules := &clientcmd.ClientConfigLoadingRules{Precedence: []string{missing, first, second}}
+	merged, err := rules.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &clientcmdapi.AuthInfo{Exec: &clientcmdapi.ExecConfig{Command: "kubectl", Args: []string{"login", "get-token"}, APIVersion: execAPIVersionV1}}
+	mergeKubeconfigEntries(merged, names, &clientcmdapi.Cluster{Server: "https://kube:6443"}, &clientcmdapi.Context{Cluster: names.Cluster, AuthInfo: names.User}, user, "")
+	if err = writeKubeconfig(rules, merged); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = clientcmd.LoadFromFile(missing); err == nil {
+		t.Errorf("the missing kubeconfig %s has been created", missing)
+	}
+	f, s := loadTestKubeconfig(t, first), loadTestKubeconfig(t, second)
+	if _, ok := f.Contexts[names.Context]; !ok {
+		t.Errorf("the new context has not been added to the first existing file")
+	}
+	if _, ok := s.Contexts[names.Context]; ok {
+		t.Errorf("the new context has been added to the second file")
+	}
+	if _, ok := f.Clusters[names.Cluster]; ok {
+		t.Errorf("the cluster defined by the second file has been added to the first one")
+	}
+	if c, ok := s.Clusters[names.Cluster]; !ok || c.Server != "https://kube:6443" {
+		t.Errorf("the cluster has not been updated in the second file: %v", c)
+	}
+	if u, ok := s.AuthInfos[names.User]; !ok || u.Exec == nil || len(u.Token) > 0 {
+		t.Errorf("the user has not been updated in the second file: %v", u)
+	}
+
+	viper.Set(key(KubeconfigGeneratedPath), strings.Join(rules.GetLoadingPrecedence(), string(filepath.ListSeparator)))
+	viper.Set(key(KubeconfigCluster), names.Cluster)
+	viper.Set(key(KubeconfigContext), names.Context)
+	viper.Set(key(KubeconfigUser), names.User)
+	if err = removeKubeconfigEntries(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, s = loadTestKubeconfig(t, first), loadTestKubeconfig(t, second)
+	if _, ok := f.Contexts[names.Context]; ok {
+		t.Errorf("the context has not been removed from the first file")
+	}
+	if _, ok := s.Clusters[names.Cluster]; ok {
+		t.Errorf("the cluster has not been removed from the second file")
+	}
+	if _, ok := s.AuthInfos[names.User]; ok {
+		t.Errorf("the user has not been removed from the second file")
+	}
+	if _, ok := f.Contexts["work"]; !ok || f.CurrentContext != "work" {
+		t.Errorf("the unrelated context has been changed")
+	}
+}
+
+func TestKubeconfigPath(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	dir := t.TempDir()
+	first, second, generated := filepath.Join(dir, "first"), filepath.Join(dir, "second"), filepath.Join(dir, "generated", "config")
+	names := &kubeconfigNames{Cluster: "https_kube_6443", Context: "oidc", User: "oidc"}
+
+	cfg := clientcmdapi.NewConfig()
+	cfg.Clusters["work"] = &clientcmdapi.Cluster{Server: "https://work:6443"}
+	cfg.Contexts["work"] = &clientcmdapi.Context{Cluster: "work", AuthInfo: "work"}
+	cfg.CurrentContext = "work"
+	writeTestKubeconfig(t, first, cfg)
+
+	cfg = clientcmdapi.NewConfig()
+	cfg.Clusters[names.Cluster] = &clientcmdapi.Cluster{Server: "https://old:6443"}
+	cfg.AuthInfos[names.User] = &clientcmdapi.AuthInfo{Token: "old"}
+	writeTestKubeconfig(t, second, cfg)
+
+	t.Setenv(clientcmd.RecommendedConfigPathEnvVar, strings.Join([]string{first, second}, string(filepath.ListSeparator)))
+	viper.Set(key(KubeconfigPath), generated)
+
+	rules := kubeconfigLoadingRules()
+	merged, err := rules.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster := &clientcmdapi.Cluster{Server: "https://kube:6443"}
+	context := &clientcmdapi.Context{Cluster: names.Cluster, AuthInfo: names.User}
+	user := &clientcmdapi.AuthInfo{Exec: &clientcmdapi.ExecConfig{Command: "kubectl", Args: []string{"login", "get-token"}, APIVersion: execAPIVersionV1}}
+	// The entries of the KUBECONFIG files are still checked for conflicts
+	if conflicts := kubeconfigConflicts(merged, names, cluster, context, user); len(conflicts) != 2 {
+		t.Errorf("expected the cluster and user of the second file to conflict, got %v", conflicts)
+	}
+	mergeKubeconfigEntries(merged, names, cluster, context, user, generated)
+	if err = writeKubeconfig(rules, merged); err != nil {
+		t.Fatal(err)
+	}
+
+	f, s, g := loadTestKubeconfig(t, first), loadTestKubeconfig(t, second), loadTestKubeconfig(t, generated)
+	if _, ok := g.Contexts[names.Context]; !ok {
+		t.Errorf("the new context has not been added to the --kubeconfig-path file")
+	}
+	if _, ok := f.Contexts[names.Context]; ok {
+		t.Errorf("the new context has been added to the first file")
+	}
+	if c, ok := s.Clusters[names.Cluster]; !ok || c.Server != "https://kube:6443" {
+		t.Errorf("the cluster has not been updated in the second file: %v", c)
+	}
+	if u, ok := s.AuthInfos[names.User]; !ok || u.Exec == nil {
+		t.Errorf("the user has not been updated in the second file: %v", u)
+	}
+	if len(g.Clusters) > 0 || len(g.AuthInfos) > 0 {
+		t.Errorf("the cluster and user of the second file have been duplicated in the --kubeconfig-path file")
+	}
+}
+
+func TestKubeconfigConflicts(t *testing.T) {
+	names := &kubeconfigNames{Cluster: "https_kube_6443", Context: "oidc", User: "oidc"}
+	cluster := &clientcmdapi.Cluster{Server: "https://kube:6443"}
+	context := &clientcmdapi.Context{Cluster: names.Cluster, AuthInfo: names.User}
+	user := &clientcmdapi.AuthInfo{Exec: &clientcmdapi.ExecConfig{Command: "kubectl", Args: []string{"login", "get-token", "--profile=default"}, APIVersion: execAPIVersionV1}}
+
+	testCases := map[string]struct {
+		existing *clientcmdapi.AuthInfo
+		conflict bool
+	}{
+		"same entry":          {existing: user.DeepCopy()},
+		"previous version":    {existing: &clientcmdapi.AuthInfo{Exec: &clientcmdapi.ExecConfig{Command: "kubectl", Args: []string{"login", "get-token"}, APIVersion: "client.authentication.k8s.io/v1beta1"}}},
+		"plugin binary":       {existing: &clientcmdapi.AuthInfo{Exec: &clientcmdapi.ExecConfig{Command: "/usr/local/bin/kubectl-login", Args: []string{"get-token", "--profile", "default"}}}},
+		"another kubectl cmd": {existing: &clientcmdapi.AuthInfo{Exec: &clientcmdapi.ExecConfig{Command: "kubectl", Args: []string{"oidc-login", "get-token"}}}, conflict: true},
+		"other arguments":     {existing: &clientcmdapi.AuthInfo{Exec: &clientcmdapi.ExecConfig{Command: "kubectl", Args: []string{"login", "get-token", "--refresh-window=1m"}}}, conflict: true},
+		"another plugin":      {existing: &clientcmdapi.AuthInfo{Exec: &clientcmdapi.ExecConfig{Command: "aws", Args: []string{"eks", "get-token"}}}, conflict: true},
+		"static token":        {existing: &clientcmdapi.AuthInfo{Token: "secret"}, conflict: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfg := clientcmdapi.NewConfig()
+			cfg.Clusters[names.Cluster] = cluster.DeepCopy()
+			cfg.Contexts[names.Context] = context.DeepCopy()
+			cfg.AuthInfos[names.User] = tc.existing
+
+			conflicts := kubeconfigConflicts(cfg, names, cluster, context, user)
+			if (len(conflicts) > 0) != tc.conflict {
+				t.Errorf("expected conflict %t, got %v", tc.conflict, conflicts)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/clastix/kubectl-login/internal/actions"
+)
+
+const (
+	// Probing the Kubernetes API server is skipped only on demand, e.g. when not reachable from where the login happens
+	skipProbeFlag     = "k8s-skip-probe"
+	defaultK8STimeout = 30 * time.Second
+)
+
+// clusterEntry returns the kubeconfig cluster of the Kubernetes API server configured for the selected profile.
+func clusterEntry() (*clientcmdapi.Cluster, error) {
+	server := viper.GetString(key(K8SAPIServer))
+	if u, err := url.Parse(server); err != nil || len(u.Host) == 0 || (u.Scheme != "https" && u.Scheme != "http") {
+		return nil, fmt.Errorf("the Kubernetes API server %q is not a valid URL", server)
+	}
+
+	cluster := &clientcmdapi.Cluster{
+		Server:                server,
+		InsecureSkipTLSVerify: viper.GetBool(key(K8SSkipTLSVerify)),
+		TLSServerName:         viper.GetString(key(K8STLSServerName)),
+		ProxyURL:              viper.GetString(key(K8SProxyURL)),
+	}
+
+	if p := cluster.ProxyURL; len(p) > 0 {
+		u, err := url.Parse(p)
+		if err != nil || len(u.Host) == 0 {
+			return nil, fmt.Errorf("the Kubernetes API server proxy %q is not a valid URL", p)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("the Kubernetes API server proxy scheme %s is not supported, one of http, https, socks5", u.Scheme)
+		}
+	}
+
+	caPath, caData := viper.GetString(key(K8SCertificateAuthorityPath)), viper.GetString(key(K8SCertificateAuthorityData))
+	switch {
+	case len(caPath) > 0 && len(caData) > 0:
+		return nil, fmt.Errorf("the --%s and --%s flags are mutually exclusive", flagsMap[K8SCertificateAuthorityPath], flagsMap[K8SCertificateAuthorityData])
+	case cluster.InsecureSkipTLSVerify:
+		// kubectl rejects a cluster skipping the verification along with a certificate authority
+		logger.Debug("Skipping the Kubernetes API server TLS verification, the certificate authority is ignored")
+	case len(caPath) > 0:
+		b, err := afero.ReadFile(afero.NewOsFs(), caPath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read Kubernetes CA from file (%w)", err)
+		}
+		cluster.CertificateAuthorityData = b
+	case len(caData) > 0:
+		b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(caData))
+		if err != nil {
+			return nil, fmt.Errorf("the --%s value is not base64 encoded (%w)", flagsMap[K8SCertificateAuthorityData], err)
+		}
+		cluster.CertificateAuthorityData = b
+	}
+	if len(cluster.CertificateAuthorityData) > 0 && !x509.NewCertPool().AppendCertsFromPEM(cluster.CertificateAuthorityData) {
+		return nil, fmt.Errorf("the Kubernetes API server certificate authority contains no PEM encoded certificate")
+	}
+
+	return cluster, nil
+}
+
+// clusterRestConfig returns the client configuration of the given cluster entry, as built by kubectl from the kubeconfig.
+func clusterRestConfig(cluster *clientcmdapi.Cluster, token string) (*rest.Config, error) {
+	const name = "kubectl-login"
+
+	cfg := clientcmdapi.NewConfig()
+	cfg.Clusters[name] = cluster
+	cfg.AuthInfos[name] = &clientcmdapi.AuthInfo{Token: token}
+	cfg.Contexts[name] = &clientcmdapi.Context{Cluster: name, AuthInfo: name}
+	cfg.CurrentContext = name
+
+	config, err := clientcmd.NewDefaultClientConfig(*cfg, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("invalid Kubernetes API server configuration (%w)", err)
+	}
+	config.Timeout = viper.GetDuration(key(K8STimeoutDuration))
+
+	return config, nil
+}
+
+// probeCluster checks the Kubernetes API server can be reached and its certificate verified with the cluster entry.
+func probeCluster(cluster *clientcmdapi.Cluster) error {
+	config, err := clusterRestConfig(cluster, "")
+	if err != nil {
+		return err
+	}
+
+	v, err := actions.NewAPIServerVersion(logger, config).Handle()
+	if err != nil {
+		return fmt.Errorf("cannot connect to the Kubernetes API server %s, check the endpoint and its certificate authority set with --%s or --%s, or skip the check with --%s (%w)", cluster.Server, flagsMap[K8SCertificateAuthorityPath], flagsMap[K8SCertificateAuthorityData], skipProbeFlag, err)
+	}
+	logger.Info("Kubernetes API server verified", zap.String("server", cluster.Server), zap.String("version", v))
+
+	return nil
+}
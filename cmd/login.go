@@ -0,0 +1,329 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"golang.org/x/term"
+
+	"github.com/clastix/kubectl-login/internal/actions"
+	"github.com/clastix/kubectl-login/internal/browser"
+	"github.com/clastix/kubectl-login/internal/oidc"
+	"github.com/clastix/kubectl-login/internal/qrcode"
+)
+
+// promptOutput receives the login instructions and prompts, switched to the standard error by get-token.
+var promptOutput io.Writer = os.Stdout
+
+// interactive tells whether the user can be prompted: get-token follows the interactivity reported by kubectl.
+var interactive = term.IsTerminal(int(os.Stdin.Fd()))
+
+// clientAuthentication returns the credentials authenticating the configured client with the given method.
+func clientAuthentication(method string) (*oidc.ClientAuthentication, error) {
+	secret, err := oidcClientSecret()
+	if err != nil {
+		return nil, err
+	}
+	auth, err := oidc.NewClientAuthentication(method, viper.GetString(key(OIDCClientID)), secret, viper.GetString(key(OIDCClientPrivateKey)), viper.GetString(key(OIDCClientKeyID)), viper.GetString(key(TokenEndpoint)))
+	if err != nil {
+		return nil, fmt.Errorf("cannot configure the client authentication (%w)", err)
+	}
+	return auth, nil
+}
+
+// authenticate discovers the OIDC server configuration and logs in, leaving the tokens to be saved by the caller.
+func authenticate(client *oidc.HTTPClient) (token, refresh string, claims jwt.MapClaims, err error) {
+	// Gathering the OIDC server configuration
+	var res *actions.OIDCResponse
+	if res, err = actions.NewOIDCConfiguration(logger, client, discoveryCachePath(viper.GetString(key(OIDCServer)))).Handle(viper.GetString(key(OIDCServer))); err != nil {
+		return "", "", nil, fmt.Errorf("cannot obtain the OIDC configuration (%w)", err)
+	}
+
+	// Authenticating the client against the token endpoint, public clients are just identified by their ID
+	var secret, method string
+	if secret, err = oidcClientSecret(); err != nil {
+		return
+	}
+	method, err = oidc.ResolveClientAuthMethod(viper.GetString(key(OIDCClientAuthMethod)), secret, viper.GetString(key(OIDCClientPrivateKey)), res.TokenEndpointAuthMethods)
+	if err != nil {
+		return
+	}
+	// The token endpoint is the audience of the private_key_jwt assertions
+	viper.Set(key(TokenEndpoint), res.TokenEndpoint)
+	var auth *oidc.ClientAuthentication
+	if auth, err = clientAuthentication(method); err != nil {
+		return
+	}
+
+	viper.Set(key(TokenIssuer), res.Issuer)
+	viper.Set(key(TokenJWKSURI), res.JWKSURI)
+	var verifier *oidc.IDTokenVerifier
+	if verifier, err = idTokenVerifier(client); err != nil {
+		return
+	}
+
+	if token, refresh, claims, err = login(client, auth, verifier, res); err != nil {
+		return
+	}
+
+	viper.Set(key(TokenAuthMethod), method)
+
+	return token, refresh, claims, nil
+}
+
+// login obtains the tokens with the configured grant type, returning the claims of the validated ID token.
+func login(client *oidc.HTTPClient, auth *oidc.ClientAuthentication, verifier *oidc.IDTokenVerifier, res *actions.OIDCResponse) (token, refresh string, claims jwt.MapClaims, err error) {
+	grant := viper.GetString(key(OIDCGrantType))
+	if err = checkCapabilities(grant, res); err != nil {
+		return "", "", nil, err
+	}
+
+	var nonce string
+	switch grant {
+	case "", GrantTypeAuthorizationCode:
+		token, refresh, nonce, err = authorizationCodeLogin(client, auth, res)
+	case GrantTypeDeviceCode:
+		token, refresh, err = deviceCodeLogin(client, auth, res)
+	case GrantTypePassword:
+		token, refresh, err = passwordLogin(client, auth, res)
+	case GrantTypeClientCredentials:
+		token, refresh, err = clientCredentialsLogin(client, auth, res)
+	default:
+		err = fmt.Errorf("unsupported grant type %s", grant)
+	}
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	if claims, err = verifier.Verify(token); err != nil {
+		return "", "", nil, fmt.Errorf("cannot validate the issued ID token (%w)", err)
+	}
+	// The nonce is sent only with the authentication request of the Authorization Code Grant
+	if len(nonce) > 0 {
+		if err = oidc.VerifyNonce(claims, nonce); err != nil {
+			return "", "", nil, fmt.Errorf("cannot validate the issued ID token (%w)", err)
+		}
+	}
+
+	return token, refresh, claims, nil
+}
+
+// checkCapabilities verifies the OIDC server supports the given grant type.
+func checkCapabilities(grant string, res *actions.OIDCResponse) error {
+	hint := fmt.Sprintf("choose another grant type with --%s", flagsMap[OIDCGrantType])
+	switch grant {
+	case "", GrantTypeAuthorizationCode:
+		if len(res.AuthorizationEndpoint) == 0 || !res.SupportsGrantType("authorization_code") {
+			return fmt.Errorf("the OIDC server doesn't support the authorization code grant, %s", hint)
+		}
+		if !res.SupportsCodeChallengeMethod() {
+			return fmt.Errorf("the OIDC server doesn't support the PKCE S256 code challenge method (supported: %s), %s", strings.Join(res.CodeChallengeMethodsSupported, ", "), hint)
+		}
+	case GrantTypeDeviceCode:
+		if len(res.DeviceAuthorizationEndpoint) == 0 || !res.SupportsGrantType("urn:ietf:params:oauth:grant-type:device_code") {
+			return fmt.Errorf("the OIDC server doesn't support the device code grant, %s", hint)
+		}
+	case GrantTypePassword:
+		if !res.SupportsGrantType("password") {
+			return fmt.Errorf("the OIDC server doesn't support the password grant, %s", hint)
+		}
+	case GrantTypeClientCredentials:
+		if !res.SupportsGrantType("client_credentials") {
+			return fmt.Errorf("the OIDC server doesn't support the client credentials grant, %s", hint)
+		}
+	}
+	return nil
+}
+
+// authorizationCodeLogin performs the Authorization Code Grant with PKCE.
+func authorizationCodeLogin(client *oidc.HTTPClient, auth *oidc.ClientAuthentication, res *actions.OIDCResponse) (token, refresh, nonce string, err error) {
+	pkce := actions.NewCodeVerifier(logger).Handle()
+	if nonce, err = actions.NewNonce(logger).Handle(); err != nil {
+		return "", "", "", fmt.Errorf("cannot generate the nonce (%w)", err)
+	}
+
+	redirectURI := actions.OOBRedirectURI
+	var server *actions.LoopbackServer
+	if !viper.GetBool(key(OIDCRedirectOOB)) {
+		if server, err = actions.NewLoopbackServer(logger, viper.GetInt(key(OIDCRedirectPort))); err != nil {
+			return "", "", "", fmt.Errorf("cannot start the redirect listener, consider the out-of-band flow with --%s (%w)", flagsMap[OIDCRedirectOOB], err)
+		}
+		redirectURI = server.RedirectURI()
+	}
+
+	var loginURL, state string
+	loginURL, state, err = actions.NewAuthenticationURI(logger, viper.GetString(key(OIDCClientID)), pkce, nonce, redirectURI, res).Handle()
+	if err != nil {
+		return "", "", "", fmt.Errorf("cannot generate the authentatication URI (%w)", err)
+	}
+
+	fmt.Fprintln(promptOutput, "")
+	fmt.Fprintln(promptOutput, "Proceed to login to the following link using your browser:")
+	fmt.Fprintln(promptOutput, "")
+	fmt.Fprintln(promptOutput, loginURL)
+	fmt.Fprintln(promptOutput, "")
+
+	var code string
+	if server != nil {
+		if e := browser.Open(loginURL); e != nil {
+			logger.Debug("Cannot open the browser", zap.Error(e))
+		}
+
+		if code, err = server.Handle(state); err != nil {
+			return "", "", "", fmt.Errorf("cannot receive the authorization code (%w)", err)
+		}
+	} else {
+		reader := bufio.NewReader(os.Stdin)
+		fmt.Fprint(promptOutput, "Type the verification code or the response URL: ")
+		input, _ := reader.ReadString('\n')
+		if code, err = actions.NewCodeInput(logger, state).Handle(input); err != nil {
+			return "", "", "", fmt.Errorf("cannot validate the authorization response (%w)", err)
+		}
+	}
+
+	token, refresh, err = actions.NewGetToken(logger, res.TokenEndpoint, code, pkce, redirectURI, auth, client).Handle()
+	if err != nil {
+		return "", "", "", fmt.Errorf("cannot proceed to login due to an error (%w)", err)
+	}
+
+	return token, refresh, nonce, nil
+}
+
+// deviceCodeLogin performs the Device Authorization Grant (RFC 8628).
+func deviceCodeLogin(client *oidc.HTTPClient, auth *oidc.ClientAuthentication, res *actions.OIDCResponse) (token, refresh string, err error) {
+	var authorization *actions.DeviceAuthorizationResponse
+	if authorization, err = actions.NewDeviceAuthorization(logger, auth, res, client).Handle(); err != nil {
+		return "", "", fmt.Errorf("cannot start the device authorization (%w)", err)
+	}
+
+	verificationURI := authorization.VerificationURIComplete
+	if len(verificationURI) == 0 {
+		verificationURI = authorization.VerificationURI
+	}
+
+	fmt.Fprintln(promptOutput, "")
+	fmt.Fprintln(promptOutput, "Proceed to login to the following link using a browser on any device:")
+	fmt.Fprintln(promptOutput, "")
+	fmt.Fprintln(promptOutput, verificationURI)
+	fmt.Fprintln(promptOutput, "")
+	if viper.GetBool(key(OIDCDeviceQRCode)) {
+		if e := qrcode.Fprint(promptOutput, verificationURI); e != nil {
+			logger.Debug("Cannot print the QR code", zap.Error(e))
+		}
+		fmt.Fprintln(promptOutput, "")
+	}
+	fmt.Fprintf(promptOutput, "Enter the following code when asked: %s\n", authorization.UserCode)
+	fmt.Fprintln(promptOutput, "")
+
+	token, refresh, err = actions.NewDeviceToken(logger, res.TokenEndpoint, authorization, auth, client).Handle()
+	if err != nil {
+		return "", "", fmt.Errorf("cannot proceed to login due to an error (%w)", err)
+	}
+
+	return token, refresh, nil
+}
+
+// passwordLogin performs the Resource Owner Password Credentials Grant.
+func passwordLogin(client *oidc.HTTPClient, auth *oidc.ClientAuthentication, res *actions.OIDCResponse) (token, refresh string, err error) {
+	var username, password string
+	if username, err = readUsername(); err != nil {
+		return "", "", err
+	}
+	if password, err = readPassword(); err != nil {
+		return "", "", err
+	}
+
+	token, refresh, err = actions.NewPasswordToken(logger, res.TokenEndpoint, username, password, auth, client).Handle()
+	if err != nil {
+		return "", "", fmt.Errorf("cannot proceed to login due to an error (%w)", err)
+	}
+
+	return token, refresh, nil
+}
+
+// clientCredentialsLogin performs the Client Credentials Grant for machine identities.
+func clientCredentialsLogin(client *oidc.HTTPClient, auth *oidc.ClientAuthentication, res *actions.OIDCResponse) (token, refresh string, err error) {
+	if auth.Method() == oidc.ClientAuthNone {
+		return "", "", fmt.Errorf("the client credentials grant requires a client secret or private key")
+	}
+
+	if token, err = actions.NewClientCredentials(logger, res.TokenEndpoint, auth, client).Handle(); err != nil {
+		return "", "", fmt.Errorf("cannot proceed to login due to an error (%w)", err)
+	}
+
+	return token, "", nil
+}
+
+func readUsername() (string, error) {
+	if v := viper.GetString(key(OIDCUsername)); len(v) > 0 {
+		return v, nil
+	}
+	if v := os.Getenv(UsernameEnv); len(v) > 0 {
+		return v, nil
+	}
+	if !interactive {
+		return "", fmt.Errorf("missing username, provide it with --%s or the %s environment variable", flagsMap[OIDCUsername], UsernameEnv)
+	}
+
+	fmt.Fprint(promptOutput, "Username: ")
+	v, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if v = strings.TrimSpace(v); len(v) == 0 {
+		return "", fmt.Errorf("the username cannot be empty")
+	}
+	return v, nil
+}
+
+func readPassword() (string, error) {
+	if p := viper.GetString(key(OIDCPasswordFile)); len(p) > 0 {
+		b, err := afero.ReadFile(afero.NewOsFs(), p)
+		if err != nil {
+			return "", fmt.Errorf("cannot read the password file (%w)", err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+	if v, ok := os.LookupEnv(PasswordEnv); ok {
+		return v, nil
+	}
+
+	if interactive {
+		fmt.Fprint(promptOutput, "Password: ")
+		b, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(promptOutput, "")
+		if err != nil {
+			return "", fmt.Errorf("cannot read the password (%w)", err)
+		}
+		return string(b), nil
+	}
+
+	v, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("cannot read the password from the standard input (%w)", err)
+	}
+	if v = strings.TrimRight(v, "\r\n"); len(v) == 0 {
+		return "", fmt.Errorf("missing password, provide it with --%s, the %s environment variable or the standard input", flagsMap[OIDCPasswordFile], PasswordEnv)
+	}
+	return v, nil
+}
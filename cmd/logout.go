@@ -0,0 +1,182 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/clastix/kubectl-login/internal/actions"
+	"github.com/clastix/kubectl-login/internal/oidc"
+	"github.com/clastix/kubectl-login/internal/store"
+)
+
+const endSessionFlag = "end-session"
+
+var logoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "Revoke the tokens of the profile and remove the kubeconfig entries generated upon login",
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		// Not to race with a concurrent refresh saving the tokens again
+		var unlock func()
+		if unlock, err = lockProfile(profile); err != nil {
+			return
+		}
+		defer unlock()
+
+		var s store.TokenStore
+		if s, err = tokenStore(); err != nil {
+			return
+		}
+		var tokens *store.Tokens
+		if tokens, err = loadTokens(s); err != nil {
+			return
+		}
+		idToken, refreshToken := tokens.ID, tokens.Refresh
+		if len(idToken) == 0 && len(refreshToken) == 0 && len(viper.GetString(key(KubeconfigContext))) == 0 {
+			fmt.Printf("The profile %s is not logged in", profile)
+			fmt.Println("")
+			return nil
+		}
+
+		// The local session is removed even if the OIDC server cannot be reached: the failure is reported at last
+		endSession, _ := cmd.Flags().GetBool(endSessionFlag)
+		revokeErr := revokeSession(idToken, refreshToken, endSession)
+		if revokeErr != nil {
+			logger.Error("Cannot revoke the session at the OIDC server", zap.Error(revokeErr))
+		}
+
+		if err = removeKubeconfigEntries(); err != nil {
+			return
+		}
+		// The client secret is part of the profile settings, needed by the next login
+		if len(tokens.ClientSecret) > 0 {
+			err = s.Save(profile, &store.Tokens{ClientSecret: tokens.ClientSecret})
+		} else {
+			err = s.Delete(profile)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot remove the tokens (%w)", err)
+		}
+		if err = removeProfileSettings(Token, KubeconfigGenerated); err != nil {
+			return
+		}
+
+		fmt.Printf("The profile %s has been logged out", profile)
+		fmt.Println("")
+
+		if revokeErr != nil {
+			return fmt.Errorf("the local session has been removed, but the OIDC server one could not be terminated (%w)", revokeErr)
+		}
+
+		return nil
+	},
+}
+
+// revokeSession revokes the refresh token (RFC 7009) and, when requested, ends the session at the OIDC server.
+func revokeSession(idToken, refreshToken string, endSession bool) (err error) {
+	var client *oidc.HTTPClient
+	if client, err = oidcClient(); err != nil {
+		return
+	}
+
+	var res *actions.OIDCResponse
+	if res, err = actions.NewOIDCConfiguration(logger, client, discoveryCachePath(viper.GetString(key(OIDCServer)))).Handle(viper.GetString(key(OIDCServer))); err != nil {
+		return fmt.Errorf("cannot obtain the OIDC configuration (%w)", err)
+	}
+
+	if len(refreshToken) > 0 {
+		if len(res.RevocationEndpoint) == 0 {
+			logger.Info("The OIDC server doesn't support the token revocation, the refresh token is just discarded")
+		} else {
+			var auth *oidc.ClientAuthentication
+			if auth, err = clientAuthentication(viper.GetString(key(TokenAuthMethod))); err != nil {
+				return
+			}
+			if err = actions.NewRevokeToken(logger, res.RevocationEndpoint, refreshToken, "refresh_token", auth, client).Handle(); err != nil {
+				return fmt.Errorf("cannot revoke the refresh token (%w)", err)
+			}
+		}
+	}
+
+	if endSession {
+		if len(res.EndSessionEndpoint) == 0 {
+			return fmt.Errorf("the OIDC server doesn't support ending the session")
+		}
+		if err = actions.NewEndSession(logger, res.EndSessionEndpoint, viper.GetString(key(OIDCClientID)), idToken, client).Handle(); err != nil {
+			return fmt.Errorf("cannot end the session (%w)", err)
+		}
+	}
+
+	return nil
+}
+
+// removeKubeconfigEntries deletes the entries generated upon login, restoring the previous current context.
+func removeKubeconfigEntries() error {
+	p, name := viper.GetString(key(KubeconfigGeneratedPath)), viper.GetString(key(KubeconfigContext))
+	if len(p) == 0 || len(name) == 0 {
+		return nil
+	}
+
+	// The entries are removed from the kubeconfig files they were written to, as listed upon login
+	rules := &clientcmd.ClientConfigLoadingRules{Precedence: filepath.SplitList(p)}
+	cfg, err := rules.Load()
+	if err != nil {
+		logger.Info("Cannot load the kubeconfig, skipping the entries removal", zap.String("path", p), zap.Error(err))
+		return nil
+	}
+
+	delete(cfg.Contexts, name)
+
+	cluster, user := viper.GetString(key(KubeconfigCluster)), viper.GetString(key(KubeconfigUser))
+	clusterInUse, userInUse := false, false
+	for _, c := range cfg.Contexts {
+		clusterInUse = clusterInUse || c.Cluster == cluster
+		userInUse = userInUse || c.AuthInfo == user
+	}
+	if !clusterInUse {
+		delete(cfg.Clusters, cluster)
+	}
+	if !userInUse {
+		delete(cfg.AuthInfos, user)
+	}
+
+	if cfg.CurrentContext == name {
+		cfg.CurrentContext = ""
+		if previous := viper.GetString(key(KubeconfigPreviousContext)); len(previous) > 0 {
+			if _, ok := cfg.Contexts[previous]; ok {
+				cfg.CurrentContext = previous
+			}
+		}
+	}
+
+	if err = writeKubeconfig(rules, cfg); err != nil {
+		return fmt.Errorf("cannot save the kubeconfig (%w)", err)
+	}
+
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(logoutCmd)
+	logoutCmd.Flags().Bool(endSessionFlag, false, "End the user session at the OIDC server too, as defined by OpenID Connect RP-Initiated Logout")
+}
@@ -0,0 +1,61 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/clastix/kubectl-login/internal/oidc"
+)
+
+const (
+	defaultOIDCTimeout    = 30 * time.Second
+	defaultOIDCMaxRetries = 3
+)
+
+// oidcClient returns the HTTP client of the OIDC server configured for the selected profile.
+func oidcClient() (*oidc.HTTPClient, error) {
+	return oidc.NewHTTPClient(oidc.HTTPClientOptions{
+		CertificateAuthorityPath: viper.GetString(key(OIDCCertificateAuthority)),
+		Timeout:                  viper.GetDuration(key(OIDCTimeoutDuration)),
+		InsecureSkipVerify:       viper.GetBool(key(OIDCSkipTLSVerify)),
+		ClientCertificatePath:    viper.GetString(key(OIDCClientCertificate)),
+		ClientKeyPath:            viper.GetString(key(OIDCClientKey)),
+		ProxyURL:                 viper.GetString(key(OIDCProxyURL)),
+		NoProxy:                  viper.GetString(key(OIDCNoProxy)),
+		Headers:                  viper.GetStringMapString(key(OIDCHeaders)),
+		MaxRetries:               viper.GetInt(key(OIDCMaxRetries)),
+	})
+}
+
+// parseHeaders returns the headers given in the "Name: value" format.
+func parseHeaders(values []string) (map[string]string, error) {
+	headers := make(map[string]string, len(values))
+	for _, v := range values {
+		parts := strings.SplitN(v, ":", 2)
+		if len(parts) != 2 || len(strings.TrimSpace(parts[0])) == 0 {
+			return nil, fmt.Errorf("the header %q is not in the \"Name: value\" format", v)
+		}
+		headers[http.CanonicalHeaderKey(strings.TrimSpace(parts[0]))] = strings.TrimSpace(parts[1])
+	}
+	return headers, nil
+}
@@ -0,0 +1,149 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultProfile = "default"
+	profilesKey    = "profiles"
+)
+
+// Viper keys are case-insensitive and dot-separated: profile names are restricted accordingly
+var profileNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Top-level keys of the configuration layout preceding the profiles
+var legacyKeys = []string{"kubernetes", "oidc", "token"}
+
+var profile = defaultProfile
+
+// key returns the viper key of the given setting in the selected profile.
+func key(k string) string {
+	return strings.Join([]string{profilesKey, profile, k}, ".")
+}
+
+func setProfile(name string) error {
+	if len(name) == 0 {
+		name = defaultProfile
+	}
+	if !profileNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q, only lowercase alphanumeric characters, '-' and '_' are allowed", name)
+	}
+
+	profile = name
+	setDefaults()
+
+	return nil
+}
+
+// setDefaults configures the default values of the selected profile settings.
+func setDefaults() {
+	viper.SetDefault(key(OIDCTimeoutDuration), defaultOIDCTimeout)
+	viper.SetDefault(key(K8STimeoutDuration), defaultK8STimeout)
+	viper.SetDefault(key(OIDCMaxRetries), defaultOIDCMaxRetries)
+	viper.SetDefault(key(OIDCClockSkew), defaultClockSkew)
+	viper.SetDefault(key(TokenRefreshWindow), defaultRefreshWindow)
+	viper.SetDefault(key(KubeconfigBackups), defaultKubeconfigBackups)
+}
+
+// migrateLegacyConfig moves the settings of the flat configuration layout into the default profile.
+func migrateLegacyConfig() error {
+	settings := viper.AllSettings()
+
+	found := false
+	for k := range settings {
+		found = found || isLegacyKey(k)
+	}
+	if !found {
+		return nil
+	}
+	if viper.IsSet(strings.Join([]string{profilesKey, defaultProfile}, ".")) {
+		logger.Info("Both the legacy settings and the default profile are present, skipping the migration")
+		return nil
+	}
+
+	logger.Info("Migrating the configuration into the default profile", zap.String("file", viper.ConfigFileUsed()))
+
+	// Viper cannot unset keys: the migrated configuration is written from scratch and read again
+	migrated := viper.New()
+	migrated.SetConfigFile(viper.ConfigFileUsed())
+	migrated.SetConfigType("yaml")
+	for k, v := range settings {
+		if isLegacyKey(k) {
+			k = strings.Join([]string{profilesKey, defaultProfile, k}, ".")
+		}
+		migrated.Set(k, v)
+	}
+	if err := writeConfigFile(migrated); err != nil {
+		return fmt.Errorf("cannot write the migrated configuration (%w)", err)
+	}
+
+	return viper.ReadInConfig()
+}
+
+// removeProfileSettings deletes the given settings of the selected profile from the configuration file.
+func removeProfileSettings(keys ...string) error {
+	stored := viper.New()
+	stored.SetConfigFile(viper.ConfigFileUsed())
+	stored.SetConfigType("yaml")
+	if err := stored.ReadInConfig(); err != nil {
+		return fmt.Errorf("cannot read the configuration (%w)", err)
+	}
+
+	settings := stored.AllSettings()
+	for _, k := range keys {
+		deleteNestedKey(settings, strings.Split(key(k), "."))
+	}
+
+	cleaned := viper.New()
+	cleaned.SetConfigFile(viper.ConfigFileUsed())
+	cleaned.SetConfigType("yaml")
+	for k, v := range settings {
+		cleaned.Set(k, v)
+	}
+	if err := writeConfigFile(cleaned); err != nil {
+		return err
+	}
+
+	return viper.ReadInConfig()
+}
+
+func deleteNestedKey(m map[string]interface{}, path []string) {
+	if len(path) == 1 {
+		delete(m, path[0])
+		return
+	}
+	if sub, ok := m[path[0]].(map[string]interface{}); ok {
+		deleteNestedKey(sub, path[1:])
+	}
+}
+
+func isLegacyKey(k string) bool {
+	for _, l := range legacyKeys {
+		if k == l {
+			return true
+		}
+	}
+	return false
+}
@@ -17,40 +17,25 @@ limitations under the License.
 package cmd
 
 import (
-	"bufio"
+	"encoding/base64"
 	"errors"
 	"fmt"
-	"net/url"
 	"os"
-	"path"
+	"path/filepath"
 	"strings"
 
-	"github.com/mitchellh/go-homedir"
-	"github.com/spf13/afero"
+	"github.com/dgrijalva/jwt-go"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
-	"k8s.io/client-go/tools/clientcmd"
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 
-	"github.com/clastix/kubectl-login/internal/actions"
 	"github.com/clastix/kubectl-login/internal/oidc"
+	"github.com/clastix/kubectl-login/internal/store"
 )
 
 var cfgFile string
 
-var defaultKubeConfigPath = func() string {
-	if c := os.Getenv("KUBECONFIG"); len(c) > 0 {
-		return c
-	}
-	home, _ := homedir.Dir()
-	p := path.Join(home, ".kube", "config")
-	if ok, _ := afero.Exists(afero.NewOsFs(), p); !ok {
-		_ = afero.WriteFile(afero.NewOsFs(), p, []byte{}, 0600)
-	}
-	return p
-}
-
 var rootCmd = &cobra.Command{
 	Use:   "login",
 	Short: "CLI utility to discover and securely login Kubernetes clusters across multiple operating environments",
@@ -61,53 +46,162 @@ Based on the configured authentication mechanism (e.g. TLS client, OIDC), it wil
 they are allowed to access and generate a kubeconfig for a chosen cluster.`,
 	SilenceUsage: true,
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) (err error) {
-		if ok, _ := cmd.Flags().GetBool("verbose"); ok {
-			logger, err = zap.NewDevelopment()
-			if err != nil {
-				return
-			}
+		if err = setupCommand(cmd); err != nil {
+			return
 		}
 
 		if v, _ := cmd.Flags().GetString(flagsMap[OIDCServer]); len(v) > 0 {
-			viper.Set(OIDCServer, v)
+			viper.Set(key(OIDCServer), v)
 		}
 		if v, _ := cmd.Flags().GetString(flagsMap[OIDCClientID]); len(v) > 0 {
-			viper.Set(OIDCClientID, v)
+			viper.Set(key(OIDCClientID), v)
+		}
+		// The client secret is kept by the token store, never written in the configuration file
+		if v, _ := cmd.Flags().GetString(flagsMap[OIDCClientSecret]); len(v) > 0 {
+			clientSecret = v
+		} else if v, ok := os.LookupEnv(ClientSecretEnv); ok && len(v) > 0 {
+			clientSecret = v
+		}
+		if v, _ := cmd.Flags().GetString(flagsMap[OIDCClientAuthMethod]); len(v) > 0 {
+			viper.Set(key(OIDCClientAuthMethod), v)
+		}
+		if v, _ := cmd.Flags().GetString(flagsMap[OIDCClientPrivateKey]); len(v) > 0 {
+			viper.Set(key(OIDCClientPrivateKey), v)
+		}
+		if v, _ := cmd.Flags().GetString(flagsMap[OIDCClientKeyID]); len(v) > 0 {
+			viper.Set(key(OIDCClientKeyID), v)
 		}
 
 		if cmd.Flag(flagsMap[OIDCTimeoutDuration]).Changed {
 			v, _ := cmd.Flags().GetDuration(flagsMap[OIDCTimeoutDuration])
-			viper.Set(OIDCTimeoutDuration, v)
+			viper.Set(key(OIDCTimeoutDuration), v)
 		}
 		if cmd.Flag(flagsMap[OIDCSkipTLSVerify]).Changed {
 			v, _ := cmd.Flags().GetBool(flagsMap[OIDCSkipTLSVerify])
-			viper.Set(OIDCSkipTLSVerify, v)
+			viper.Set(key(OIDCSkipTLSVerify), v)
 		}
 		if v, _ := cmd.Flags().GetString(flagsMap[OIDCCertificateAuthority]); len(v) > 0 {
-			viper.Set(OIDCCertificateAuthority, v)
+			viper.Set(key(OIDCCertificateAuthority), v)
+		}
+		if v, _ := cmd.Flags().GetString(flagsMap[OIDCClientCertificate]); len(v) > 0 {
+			viper.Set(key(OIDCClientCertificate), v)
+		}
+		if v, _ := cmd.Flags().GetString(flagsMap[OIDCClientKey]); len(v) > 0 {
+			viper.Set(key(OIDCClientKey), v)
+		}
+		if v, _ := cmd.Flags().GetString(flagsMap[OIDCProxyURL]); len(v) > 0 {
+			viper.Set(key(OIDCProxyURL), v)
+		}
+		if v, _ := cmd.Flags().GetString(flagsMap[OIDCNoProxy]); len(v) > 0 {
+			viper.Set(key(OIDCNoProxy), v)
+		}
+		if v, _ := cmd.Flags().GetStringArray(flagsMap[OIDCHeaders]); len(v) > 0 {
+			var headers map[string]string
+			if headers, err = parseHeaders(v); err != nil {
+				return
+			}
+			viper.Set(key(OIDCHeaders), headers)
+		}
+		if cmd.Flag(flagsMap[OIDCMaxRetries]).Changed {
+			v, _ := cmd.Flags().GetInt(flagsMap[OIDCMaxRetries])
+			if v < 0 {
+				return fmt.Errorf("the --%s flag cannot be negative", flagsMap[OIDCMaxRetries])
+			}
+			viper.Set(key(OIDCMaxRetries), v)
+		}
+		if cmd.Flag(flagsMap[OIDCClockSkew]).Changed {
+			v, _ := cmd.Flags().GetDuration(flagsMap[OIDCClockSkew])
+			viper.Set(key(OIDCClockSkew), v)
+		}
+		if cmd.Flag(flagsMap[OIDCRedirectPort]).Changed {
+			v, _ := cmd.Flags().GetInt(flagsMap[OIDCRedirectPort])
+			viper.Set(key(OIDCRedirectPort), v)
+		}
+		if cmd.Flag(flagsMap[OIDCRedirectOOB]).Changed {
+			v, _ := cmd.Flags().GetBool(flagsMap[OIDCRedirectOOB])
+			viper.Set(key(OIDCRedirectOOB), v)
+		}
+		if v, _ := cmd.Flags().GetString(flagsMap[OIDCGrantType]); len(v) > 0 {
+			viper.Set(key(OIDCGrantType), v)
+		}
+		if cmd.Flag(flagsMap[OIDCDeviceQRCode]).Changed {
+			v, _ := cmd.Flags().GetBool(flagsMap[OIDCDeviceQRCode])
+			viper.Set(key(OIDCDeviceQRCode), v)
+		}
+		if v, _ := cmd.Flags().GetString(flagsMap[OIDCUsername]); len(v) > 0 {
+			viper.Set(key(OIDCUsername), v)
+		}
+		if v, _ := cmd.Flags().GetString(flagsMap[OIDCPasswordFile]); len(v) > 0 {
+			viper.Set(key(OIDCPasswordFile), v)
+		}
+
+		if v, _ := cmd.Flags().GetString(flagsMap[TokenStoreBackend]); len(v) > 0 {
+			viper.Set(key(TokenStoreBackend), v)
+		}
+		if v, _ := cmd.Flags().GetString(flagsMap[TokenStorePath]); len(v) > 0 {
+			viper.Set(key(TokenStorePath), v)
+		}
+		if v, _ := cmd.Flags().GetString(flagsMap[TokenStoreKeyFile]); len(v) > 0 {
+			viper.Set(key(TokenStoreKeyFile), v)
 		}
 
 		if v, _ := cmd.Flags().GetString(flagsMap[K8SAPIServer]); len(v) > 0 {
-			viper.Set(K8SAPIServer, v)
+			viper.Set(key(K8SAPIServer), v)
 		}
 		if cmd.Flag(flagsMap[K8SSkipTLSVerify]).Changed {
 			v, _ := cmd.Flags().GetBool(flagsMap[K8SSkipTLSVerify])
-			viper.Set(K8SSkipTLSVerify, v)
+			viper.Set(key(K8SSkipTLSVerify), v)
+		}
+		caPath, _ := cmd.Flags().GetString(flagsMap[K8SCertificateAuthorityPath])
+		caData, _ := cmd.Flags().GetString(flagsMap[K8SCertificateAuthorityData])
+		switch {
+		case len(caPath) > 0 && len(caData) > 0:
+			return fmt.Errorf("the --%s and --%s flags are mutually exclusive", flagsMap[K8SCertificateAuthorityPath], flagsMap[K8SCertificateAuthorityData])
+		// The certificate authority provided by a flag replaces the one stored in the profile, whatever the source
+		case len(caPath) > 0:
+			viper.Set(key(K8SCertificateAuthorityPath), caPath)
+			viper.Set(key(K8SCertificateAuthorityData), "")
+		case len(caData) > 0:
+			viper.Set(key(K8SCertificateAuthorityData), caData)
+			viper.Set(key(K8SCertificateAuthorityPath), "")
+		}
+		if v, _ := cmd.Flags().GetString(flagsMap[K8STLSServerName]); len(v) > 0 {
+			viper.Set(key(K8STLSServerName), v)
 		}
-		if v, _ := cmd.Flags().GetString(flagsMap[K8SCertificateAuthorityPath]); len(v) > 0 {
-			viper.Set(K8SCertificateAuthorityPath, v)
+		if v, _ := cmd.Flags().GetString(flagsMap[K8SProxyURL]); len(v) > 0 {
+			viper.Set(key(K8SProxyURL), v)
+		}
+		if cmd.Flag(flagsMap[K8STimeoutDuration]).Changed {
+			v, _ := cmd.Flags().GetDuration(flagsMap[K8STimeoutDuration])
+			viper.Set(key(K8STimeoutDuration), v)
 		}
 		if v, _ := cmd.Flags().GetString(flagsMap[KubeconfigPath]); len(v) > 0 {
-			viper.Set(KubeconfigPath, v)
+			viper.Set(key(KubeconfigPath), v)
+		}
+		if v, _ := cmd.Flags().GetString(flagsMap[K8SExecAPIVersion]); len(v) > 0 {
+			viper.Set(key(K8SExecAPIVersion), v)
+		}
+		if v, _ := cmd.Flags().GetString(flagsMap[K8SClusterName]); len(v) > 0 {
+			viper.Set(key(K8SClusterName), v)
+		}
+		if v, _ := cmd.Flags().GetString(flagsMap[K8SContextName]); len(v) > 0 {
+			viper.Set(key(K8SContextName), v)
+		}
+		if v, _ := cmd.Flags().GetString(flagsMap[K8SUserName]); len(v) > 0 {
+			viper.Set(key(K8SUserName), v)
+		}
+		if cmd.Flag(flagsMap[KubeconfigBackups]).Changed {
+			v, _ := cmd.Flags().GetInt(flagsMap[KubeconfigBackups])
+			viper.Set(key(KubeconfigBackups), v)
 		}
 
-		if v := viper.GetString(OIDCServer); len(v) == 0 {
+		if v := viper.GetString(key(OIDCServer)); len(v) == 0 {
 			return errors.New("missing OIDC server endpoint")
 		}
-		if v := viper.GetString(OIDCClientID); len(v) == 0 {
+		if v := viper.GetString(key(OIDCClientID)); len(v) == 0 {
 			return errors.New("missing OIDC server endpoint")
 		}
-		if v := viper.GetString(K8SAPIServer); len(v) == 0 {
+		if v := viper.GetString(key(K8SAPIServer)); len(v) == 0 {
 			return errors.New("missing Kubernetes API server")
 		}
 
@@ -116,105 +210,146 @@ they are allowed to access and generate a kubeconfig for a chosen cluster.`,
 	RunE: func(cmd *cobra.Command, args []string) (err error) {
 		logger.Info("Starting the login procedure")
 
-		// Creating OIDC server HTTP client with TLS handling
-		var client *oidc.HTTPClient
-		client, err = oidc.NewHTTPClient(viper.GetString(OIDCCertificateAuthority), viper.GetDuration(OIDCTimeoutDuration), viper.GetBool(OIDCSkipTLSVerify))
-		if err != nil {
-			return
+		dryRun, _ := cmd.Flags().GetString(dryRunFlag)
+		switch dryRun {
+		case "", dryRunDiff, dryRunKubeconfig:
+		default:
+			return fmt.Errorf("unsupported --%s output %s, one of %s, %s", dryRunFlag, dryRun, dryRunDiff, dryRunKubeconfig)
 		}
-
-		// Gathering the OIDC server configuration
-		var res *actions.OIDCResponse
-		if res, err = actions.NewOIDCConfiguration(logger, client).Handle(viper.GetString(OIDCServer)); err != nil {
-			return fmt.Errorf("cannot obtain the OIDC configuration (%w)", err)
+		migrateSecrets = len(dryRun) == 0
+
+		// The certificate authority is discovered on demand, or to verify the pinned one, replacing the configured one
+		hashes, _ := cmd.Flags().GetStringArray(caCertHashFlag)
+		discover, _ := cmd.Flags().GetBool(caDiscoveryFlag)
+		if discover = discover || len(hashes) > 0; discover {
+			switch {
+			case cmd.Flag(flagsMap[K8SCertificateAuthorityPath]).Changed, cmd.Flag(flagsMap[K8SCertificateAuthorityData]).Changed:
+				return fmt.Errorf("the certificate authority discovery cannot be used along with --%s or --%s", flagsMap[K8SCertificateAuthorityPath], flagsMap[K8SCertificateAuthorityData])
+			case viper.GetBool(key(K8SSkipTLSVerify)):
+				return fmt.Errorf("the certificate authority discovery cannot be used along with --%s", flagsMap[K8SSkipTLSVerify])
+			}
+			viper.Set(key(K8SCertificateAuthorityPath), "")
+			viper.Set(key(K8SCertificateAuthorityData), "")
 		}
 
-		pkce := actions.NewCodeVerifier(logger).Handle()
+		// The cluster settings are checked before any interaction with the OIDC server, not to login for nothing
+		var cluster *clientcmdapi.Cluster
+		if cluster, err = clusterEntry(); err != nil {
+			return
+		}
+		if discover {
+			if cluster.CertificateAuthorityData, err = discoverCertificateAuthority(cluster, hashes); err != nil {
+				return
+			}
+			viper.Set(key(K8SCertificateAuthorityData), base64.StdEncoding.EncodeToString(cluster.CertificateAuthorityData))
+		}
+		if skip, _ := cmd.Flags().GetBool(skipProbeFlag); !skip && !cluster.InsecureSkipTLSVerify {
+			if err = probeCluster(cluster); err != nil {
+				return
+			}
+		}
 
-		var loginURL string
-		loginURL, err = actions.NewAuthenticationURI(logger, viper.GetString(OIDCClientID), pkce, res).Handle()
+		// Creating OIDC server HTTP client with TLS handling
+		var client *oidc.HTTPClient
+		client, err = oidcClient()
 		if err != nil {
-			return fmt.Errorf("cannot generate the authentatication URI (%w)", err)
+			return
 		}
 
-		fmt.Println("")
-		fmt.Println("Proceed to login to the following link using your browser:")
-		fmt.Println("")
-		fmt.Println(loginURL)
-		fmt.Println("")
-
-		var code string
-		reader := bufio.NewReader(os.Stdin)
-		fmt.Print("Type the verification code: ")
-		code, _ = reader.ReadString('\n')
-		code = strings.TrimSuffix(code, "\n")
-
-		logger.Debug("User input code is " + code)
-
 		var token, refresh string
-		token, refresh, err = actions.NewGetToken(logger, res.TokenEndpoint, viper.GetString(OIDCClientID), code, pkce, client).Handle()
-		if err != nil {
-			return fmt.Errorf("cannot proceed to login due to an error (%w)", err)
+		var claims jwt.MapClaims
+		if token, refresh, claims, err = authenticate(client); err != nil {
+			return
 		}
-
-		viper.Set(TokenEndpoint, res.TokenEndpoint)
-		viper.Set(TokenID, token)
-		viper.Set(TokenRefresh, refresh)
-
-		defer func() {
-			if err = viper.WriteConfig(); err != nil {
-				logger.Error("Cannot write configuration file", zap.Error(err))
+		// A dry run leaves the profile untouched: neither the tokens nor the flags are stored
+		if len(dryRun) == 0 {
+			if err = saveTokens(&store.Tokens{ID: token, Refresh: refresh}); err != nil {
+				return
 			}
-		}()
 
-		var p string
-		if p = viper.GetString(KubeconfigPath); len(p) == 0 {
-			p = defaultKubeConfigPath()
+			defer func() {
+				// Failing to write the configuration file must not hide the login error
+				if e := writeConfigFile(viper.GetViper()); e != nil {
+					logger.Error("Cannot write configuration file", zap.Error(e))
+					if err == nil {
+						err = e
+					}
+				}
+			}()
 		}
+
+		// Reading all the kubeconfig files, as merged by kubectl
+		rules := kubeconfigLoadingRules()
 		var cfg *clientcmdapi.Config
-		var cfgErr error
-		if cfg, cfgErr = clientcmd.LoadFromFile(p); cfgErr != nil {
-			cfg, _ = clientcmd.Load(nil)
+		if cfg, err = rules.Load(); err != nil {
+			return fmt.Errorf("cannot load the kubeconfig (%w)", err)
 		}
+		before := cfg.DeepCopy()
 
-		u, _ := url.Parse(viper.GetString(K8SAPIServer))
-		name := strings.Join([]string{u.Scheme, u.Hostname(), u.Port()}, "_")
-
-		cfg.CurrentContext = "oidc"
-		cfg.Clusters[name] = &clientcmdapi.Cluster{
-			Server:                viper.GetString(K8SAPIServer),
-			InsecureSkipTLSVerify: viper.GetBool(K8SSkipTLSVerify),
-			CertificateAuthorityData: func() (b []byte) {
-				if viper.GetBool(K8SSkipTLSVerify) {
-					return nil
-				}
-				b, err = afero.ReadFile(afero.NewOsFs(), viper.GetString(K8SCertificateAuthorityPath))
-				return
-			}(),
+		var names *kubeconfigNames
+		if names, err = newKubeconfigNames(viper.GetString(key(K8SAPIServer)), claims); err != nil {
+			return
 		}
-		cfg.Contexts["oidc"] = &clientcmdapi.Context{
-			Cluster:  name,
-			AuthInfo: "oidc",
+
+		context := &clientcmdapi.Context{
+			Cluster:  names.Cluster,
+			AuthInfo: names.User,
 		}
-		cfg.AuthInfos["oidc"] = &clientcmdapi.AuthInfo{
+		user := &clientcmdapi.AuthInfo{
 			Exec: &clientcmdapi.ExecConfig{
 				Command:    "kubectl",
-				Args:       []string{"login", tokenCmd.Use},
-				APIVersion: "client.authentication.k8s.io/v1beta1",
+				Args:       []string{"login", tokenCmd.Use, fmt.Sprintf("--profile=%s", profile)},
+				APIVersion: kubeconfigExecAPIVersion(),
 			},
 		}
-		if err != nil {
-			return fmt.Errorf("cannot read Kubernetes CA from file (%w)", err)
+		if user.Exec.APIVersion == execAPIVersionV1 {
+			// Allowing the plugin to prompt the user when kubectl has a terminal attached
+			user.Exec.InteractiveMode = clientcmdapi.IfAvailableExecInteractiveMode
 		}
 
-		if err = clientcmd.WriteToFile(*cfg, p); err != nil {
+		// The conflicts are shown by the dry run output, no need to confirm their replacement
+		if conflicts := kubeconfigConflicts(cfg, names, cluster, context, user); len(conflicts) > 0 && len(dryRun) == 0 {
+			if overwrite, _ := cmd.Flags().GetBool(kubeconfigOverwriteFlag); !overwrite {
+				if err = confirmKubeconfigOverwrite(conflicts); err != nil {
+					return
+				}
+			}
+		}
+
+		mergeKubeconfigEntries(cfg, names, cluster, context, user, viper.GetString(key(KubeconfigPath)))
+		// Switching the context of an existing kubeconfig is left to the user, unless requested
+		previousContext := cfg.CurrentContext
+		if set, _ := cmd.Flags().GetBool(setCurrentContextFlag); set || len(cfg.CurrentContext) == 0 {
+			cfg.CurrentContext = names.Context
+		}
+		if len(dryRun) > 0 {
+			return printKubeconfigDryRun(os.Stdout, dryRun, rules, before, cfg)
+		}
+		// The previous context is restored upon logout, unless it was already the generated one
+		if previousContext != cfg.CurrentContext {
+			viper.Set(key(KubeconfigPreviousContext), previousContext)
+		}
+		viper.Set(key(KubeconfigGeneratedPath), strings.Join(rules.GetLoadingPrecedence(), string(filepath.ListSeparator)))
+		viper.Set(key(KubeconfigCluster), names.Cluster)
+		viper.Set(key(KubeconfigContext), names.Context)
+		viper.Set(key(KubeconfigUser), names.User)
+
+		if err = writeKubeconfig(rules, cfg); err != nil {
 			return fmt.Errorf("cannot save generated kubeconfig (%w)", err)
 		}
 
+		p := context.LocationOfOrigin
+		if len(p) == 0 {
+			p = rules.GetDefaultFilename()
+		}
 		fmt.Println("Your login procedure has been completed!")
 		fmt.Println("")
 		fmt.Printf("The Kubernetes configuration file has been merged in your current export KUBECONFIG: %s", p)
 		fmt.Println("")
+		if cfg.CurrentContext != names.Context {
+			fmt.Printf("Switch to the generated context with: kubectl config use-context %s", names.Context)
+			fmt.Println("")
+		}
 
 		fmt.Println("")
 		fmt.Println("Happy Kubernetes interaction!")
@@ -229,51 +364,103 @@ func Execute() {
 	}
 }
 
+// setupCommand configures the logger verbosity and selects the profile requested by the command flags.
+func setupCommand(cmd *cobra.Command) (err error) {
+	if ok, _ := cmd.Flags().GetBool("verbose"); ok {
+		if logger, err = zap.NewDevelopment(); err != nil {
+			return
+		}
+	}
+
+	name, _ := cmd.Flags().GetString("profile")
+	return setProfile(name)
+}
+
 func init() {
 	cobra.OnInitialize(initConfig)
 
-	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.kubectl-login.yaml)")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $XDG_CONFIG_HOME/kubectl-login/config.yaml, or $HOME/.kubectl-login.yaml when present)")
 	rootCmd.PersistentFlags().BoolP("verbose", "v", false, "Toggle the verbose logging")
+	rootCmd.PersistentFlags().String("profile", defaultProfile, "The login profile, each one holding the settings and tokens of a cluster and identity provider")
 
 	rootCmd.PersistentFlags().String(flagsMap[OIDCServer], viper.GetString(OIDCServer), "The OIDC server URL to connect to")
 	rootCmd.PersistentFlags().String(flagsMap[OIDCClientID], viper.GetString(OIDCClientID), "The OIDC client ID provided")
+	rootCmd.PersistentFlags().String(flagsMap[OIDCClientSecret], "", fmt.Sprintf("The OIDC client secret, required by confidential clients using client_secret_basic or client_secret_post, it can be provided with the %s environment variable too", ClientSecretEnv))
+	rootCmd.PersistentFlags().String(flagsMap[OIDCClientAuthMethod], viper.GetString(OIDCClientAuthMethod), "The token endpoint client authentication method (none, client_secret_basic, client_secret_post, private_key_jwt), inferred from the provided credentials when empty")
+	rootCmd.PersistentFlags().String(flagsMap[OIDCClientPrivateKey], viper.GetString(OIDCClientPrivateKey), "Path to the PEM encoded RSA or EC private key signing the private_key_jwt client assertion")
+	rootCmd.PersistentFlags().String(flagsMap[OIDCClientKeyID], viper.GetString(OIDCClientKeyID), "The key ID (kid) of the private key signing the private_key_jwt client assertion")
 	rootCmd.PersistentFlags().Bool(flagsMap[OIDCSkipTLSVerify], viper.GetBool(OIDCSkipTLSVerify), "Disable TLS certificate verification for the OIDC server")
-	rootCmd.PersistentFlags().Duration(flagsMap[OIDCTimeoutDuration], viper.GetDuration(OIDCTimeoutDuration), "Define the timeout in duration for the HTTP requests to the OIDC server")
+	rootCmd.PersistentFlags().Duration(flagsMap[OIDCTimeoutDuration], defaultOIDCTimeout, "Define the timeout in duration for the HTTP requests to the OIDC server")
 	rootCmd.PersistentFlags().String(flagsMap[OIDCCertificateAuthority], viper.GetString(OIDCCertificateAuthority), "Path to the OIDC server certificate authority PEM encoded file")
+	rootCmd.PersistentFlags().String(flagsMap[OIDCClientCertificate], viper.GetString(OIDCClientCertificate), "Path to the PEM encoded client certificate authenticating the TLS connections to the OIDC server")
+	rootCmd.PersistentFlags().String(flagsMap[OIDCClientKey], viper.GetString(OIDCClientKey), "Path to the PEM encoded private key of the OIDC client certificate")
+	rootCmd.PersistentFlags().String(flagsMap[OIDCProxyURL], viper.GetString(OIDCProxyURL), "The HTTP, HTTPS or SOCKS5 proxy URL of the requests to the OIDC server, overriding the HTTPS_PROXY and HTTP_PROXY environment variables")
+	rootCmd.PersistentFlags().String(flagsMap[OIDCNoProxy], viper.GetString(OIDCNoProxy), "Comma-separated hosts, domains and CIDRs reached without proxy, overriding the NO_PROXY environment variable")
+	rootCmd.PersistentFlags().StringArray(flagsMap[OIDCHeaders], nil, "Header added to the requests to the OIDC server in the \"Name: value\" format, it can be repeated")
+	rootCmd.PersistentFlags().Int(flagsMap[OIDCMaxRetries], defaultOIDCMaxRetries, "Retries of the requests to the OIDC server failing with network errors, 429 or 5xx statuses, zero disables them")
+	rootCmd.PersistentFlags().Duration(flagsMap[OIDCClockSkew], defaultClockSkew, "The clock skew tolerated when validating the ID token time claims")
+	rootCmd.PersistentFlags().Int(flagsMap[OIDCRedirectPort], viper.GetInt(OIDCRedirectPort), "Port of the local redirect listener receiving the authorization code, leave zero for a random one")
+	rootCmd.PersistentFlags().Bool(flagsMap[OIDCRedirectOOB], viper.GetBool(OIDCRedirectOOB), "Use the out-of-band redirect and type the verification code instead of the local redirect listener")
+	rootCmd.PersistentFlags().String(flagsMap[OIDCGrantType], "", fmt.Sprintf("The OAuth 2.0 grant used to login, one of %s (default), %s, %s, %s", GrantTypeAuthorizationCode, GrantTypeDeviceCode, GrantTypePassword, GrantTypeClientCredentials))
+	rootCmd.PersistentFlags().Bool(flagsMap[OIDCDeviceQRCode], viper.GetBool(OIDCDeviceQRCode), "Print the verification URI as a QR code when using the device code grant")
+	rootCmd.PersistentFlags().String(flagsMap[OIDCUsername], viper.GetString(OIDCUsername), fmt.Sprintf("The username used by the password grant, it can be provided with the %s environment variable too", UsernameEnv))
+	rootCmd.PersistentFlags().String(flagsMap[OIDCPasswordFile], viper.GetString(OIDCPasswordFile), fmt.Sprintf("Path to the file containing the password used by the password grant, otherwise read from the %s environment variable or the standard input", PasswordEnv))
+
+	rootCmd.PersistentFlags().String(flagsMap[TokenStoreBackend], viper.GetString(TokenStoreBackend), fmt.Sprintf("The backend storing the tokens, one of %s (default), %s, %s", store.BackendFile, store.BackendKeyring, store.BackendEncryptedFile))
+	rootCmd.PersistentFlags().String(flagsMap[TokenStorePath], viper.GetString(TokenStorePath), "Path to the token store file, leave empty for $XDG_DATA_HOME/kubectl-login/tokens.json, or tokens.enc when encrypted")
+	rootCmd.PersistentFlags().String(flagsMap[TokenStoreKeyFile], viper.GetString(TokenStoreKeyFile), fmt.Sprintf("Path to the key file encrypting the token store, otherwise the passphrase is read from the %s environment variable or the terminal", TokenStorePassphraseEnv))
 
 	rootCmd.PersistentFlags().String(flagsMap[K8SAPIServer], viper.GetString(K8SAPIServer), "Endpoint of the Kubernetes API server to connect to")
 	rootCmd.PersistentFlags().Bool(flagsMap[K8SSkipTLSVerify], viper.GetBool(K8SSkipTLSVerify), "Disable TLS certificate verification for the Kubernetes API server")
-	rootCmd.PersistentFlags().String(flagsMap[K8SCertificateAuthorityPath], viper.GetString(K8SCertificateAuthorityPath), "Path to the Kubernetes API server certificate authority PEM encoded file")
-
+	rootCmd.PersistentFlags().String(flagsMap[K8SCertificateAuthorityPath], viper.GetString(K8SCertificateAuthorityPath), "Path to the Kubernetes API server certificate authority PEM encoded file, the system roots are trusted when no certificate authority is set")
+	rootCmd.PersistentFlags().String(flagsMap[K8SCertificateAuthorityData], viper.GetString(K8SCertificateAuthorityData), "The base64 encoded Kubernetes API server certificate authority PEM, alternative to the file path")
+	rootCmd.PersistentFlags().String(flagsMap[K8STLSServerName], viper.GetString(K8STLSServerName), "Server name used to verify the Kubernetes API server certificate, when different from the endpoint host")
+	rootCmd.PersistentFlags().String(flagsMap[K8SProxyURL], viper.GetString(K8SProxyURL), "The HTTP, HTTPS or SOCKS5 proxy URL of the requests to the Kubernetes API server, written in the generated kubeconfig")
+	rootCmd.PersistentFlags().Duration(flagsMap[K8STimeoutDuration], defaultK8STimeout, "Define the timeout in duration for the HTTP requests to the Kubernetes API server sent by login and whoami")
+	rootCmd.Flags().Bool(caDiscoveryFlag, false, "Fetch the Kubernetes API server certificate authority from the kube-public/cluster-info ConfigMap, trusted once its hash is confirmed")
+	rootCmd.Flags().StringArray(caCertHashFlag, nil, "The sha256:<hash> pin of the certificate authority discovered from the cluster-info ConfigMap, as printed by kubeadm, it can be repeated")
+	rootCmd.Flags().Bool(skipProbeFlag, false, "Skip the check of the Kubernetes API server certificate against its /version endpoint, e.g. when not reachable upon login")
+
+	rootCmd.PersistentFlags().String(flagsMap[K8SExecAPIVersion], viper.GetString(K8SExecAPIVersion), "The ExecCredential API version of the generated kubeconfig (v1, v1beta1), detected from the installed kubectl when empty")
+	rootCmd.PersistentFlags().String(flagsMap[K8SClusterName], viper.GetString(K8SClusterName), "Template of the generated kubeconfig cluster name, fed with .Profile, .Scheme, .Host, .Port and the ID token .Claims (default \"{{ .Scheme }}_{{ .Host }}_{{ .Port }}\")")
+	rootCmd.PersistentFlags().String(flagsMap[K8SContextName], viper.GetString(K8SContextName), "Template of the generated kubeconfig context name, fed as the cluster one (default \"oidc\", or \"oidc-<profile>\" for the other profiles)")
+	rootCmd.PersistentFlags().String(flagsMap[K8SUserName], viper.GetString(K8SUserName), "Template of the generated kubeconfig user name, fed as the cluster one, e.g. \"{{ .Claims.email }}\" (default \"oidc\", or \"oidc-<profile>\" for the other profiles)")
+	rootCmd.Flags().Bool(setCurrentContextFlag, false, "Set the generated context as the current one of the kubeconfig, always done when no current context is set")
+	rootCmd.Flags().String(dryRunFlag, "", fmt.Sprintf("Login without writing the kubeconfig, printing instead the %s with the current one or the resulting %s", dryRunDiff, dryRunKubeconfig))
+	rootCmd.Flags().Lookup(dryRunFlag).NoOptDefVal = dryRunDiff
+	rootCmd.PersistentFlags().Int(flagsMap[KubeconfigBackups], defaultKubeconfigBackups, "Number of kubeconfig backups kept, taken before each change, zero disables them")
+	rootCmd.Flags().Bool(kubeconfigOverwriteFlag, false, "Replace the kubeconfig entries with the same names and a different configuration without asking")
 	rootCmd.PersistentFlags().String(flagsMap[KubeconfigPath], "", "Path to the generated kubeconfig file upon resulting login procedure to access the Kubernetes cluster, leave empty for the KUBECONFIG environment variable or default location ($HOME/.kube/config)")
 }
 
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
-	if cfgFile != "" {
-		// Use config file from the flag.
-		viper.SetConfigFile(cfgFile)
-	} else {
-		// Find home directory.
-		home, err := homedir.Dir()
-		if err != nil {
+	if cfgFile == "" {
+		var err error
+		if cfgFile, err = configFilePath(); err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
-
-		// Search config in home directory with name ".kubectl-login" (without extension).
-		viper.AddConfigPath(home)
-		viper.SetConfigType("yaml")
-		viper.SetConfigName(".kubectl-login")
-
-		p := path.Join(home, ".kubectl-login.yaml")
-		if ok, _ := afero.Exists(afero.NewOsFs(), p); !ok {
-			_ = afero.WriteFile(afero.NewOsFs(), p, []byte{}, os.ModePerm)
-		}
 	}
+	// The file is created only when the settings are written, not to leave empty files behind
+	viper.SetConfigFile(cfgFile)
+	viper.SetConfigType("yaml")
 
-	// If a config file is found, read it in.
-	if err := viper.ReadInConfig(); err == nil {
+	err := viper.ReadInConfig()
+	switch {
+	case err == nil:
 		logger.Info(fmt.Sprintf("Using config file: %s", viper.ConfigFileUsed()))
+
+		if err = migrateLegacyConfig(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	case os.IsNotExist(err):
+		logger.Info(fmt.Sprintf("The config file %s doesn't exist yet", cfgFile))
+	default:
+		// Going on would replace the unreadable settings upon the next write
+		fmt.Printf("Cannot read the config file %s: %s", cfgFile, err)
+		fmt.Println("")
+		os.Exit(1)
 	}
 }
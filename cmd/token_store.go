@@ -0,0 +1,210 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"golang.org/x/term"
+
+	"github.com/clastix/kubectl-login/internal/store"
+)
+
+const keyringService = "kubectl-login"
+
+var (
+	// The token store is opened once per execution, the encrypted one asking for the passphrase
+	openedTokenStore store.TokenStore
+	// clientSecret is the OIDC client secret provided upon this execution
+	clientSecret string
+	// migrateSecrets allows moving the secrets of the configuration file to the token store, not during a dry run
+	migrateSecrets = true
+)
+
+// tokenStore returns the backend configured for the selected profile, the plain file one by default.
+func tokenStore() (s store.TokenStore, err error) {
+	if openedTokenStore == nil {
+		if s, err = openTokenStore(); err != nil {
+			return nil, err
+		}
+		openedTokenStore = s
+	}
+	return openedTokenStore, nil
+}
+
+func openTokenStore() (store.TokenStore, error) {
+	backend := viper.GetString(key(TokenStoreBackend))
+	switch backend {
+	case "", store.BackendFile:
+		p, err := tokenStorePath("tokens.json")
+		if err != nil {
+			return nil, err
+		}
+		return store.NewFileStore(p), nil
+	case store.BackendKeyring:
+		return store.NewKeyringStore(keyringService), nil
+	case store.BackendEncryptedFile:
+		p, err := tokenStorePath("tokens.enc")
+		if err != nil {
+			return nil, err
+		}
+		secret, err := tokenStoreSecret()
+		if err != nil {
+			return nil, err
+		}
+		return store.NewEncryptedFileStore(p, secret)
+	default:
+		return nil, fmt.Errorf("unsupported token store %s, one of %s, %s, %s", backend, store.BackendFile, store.BackendKeyring, store.BackendEncryptedFile)
+	}
+}
+
+// tokenStorePath returns $XDG_DATA_HOME/kubectl-login/<name>, unless set with --token-store-path.
+func tokenStorePath(name string) (string, error) {
+	if p := viper.GetString(key(TokenStorePath)); len(p) > 0 {
+		return p, nil
+	}
+	return xdgPath(xdgDataHome, name)
+}
+
+// tokenStoreSecret returns the key file content, otherwise the passphrase from the environment or the terminal.
+func tokenStoreSecret() ([]byte, error) {
+	if p := viper.GetString(key(TokenStoreKeyFile)); len(p) > 0 {
+		b, err := afero.ReadFile(afero.NewOsFs(), p)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read the token store key file (%w)", err)
+		}
+		return b, nil
+	}
+	if v, ok := os.LookupEnv(TokenStorePassphraseEnv); ok && len(v) > 0 {
+		return []byte(v), nil
+	}
+	if !interactive {
+		return nil, fmt.Errorf("the encrypted token store requires a key file with --%s or the %s environment variable", flagsMap[TokenStoreKeyFile], TokenStorePassphraseEnv)
+	}
+
+	// The standard output is reserved to the ExecCredential when executed by kubectl
+	_, _ = fmt.Fprint(os.Stderr, "Token store passphrase: ")
+	b, err := term.ReadPassword(int(os.Stdin.Fd()))
+	_, _ = fmt.Fprintln(os.Stderr, "")
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the passphrase (%w)", err)
+	}
+	if len(b) == 0 {
+		return nil, errors.New("the passphrase cannot be empty")
+	}
+	return b, nil
+}
+
+// oidcClientSecret returns the client secret provided upon this execution, otherwise the one kept by the token store.
+func oidcClientSecret() (string, error) {
+	if len(clientSecret) > 0 {
+		return clientSecret, nil
+	}
+	s, err := tokenStore()
+	if err != nil {
+		return "", err
+	}
+	tokens, err := loadTokens(s)
+	if err != nil {
+		return "", err
+	}
+	clientSecret = tokens.ClientSecret
+	return clientSecret, nil
+}
+
+// saveTokens saves the tokens of the selected profile along with the client secret.
+func saveTokens(tokens *store.Tokens) error {
+	s, err := tokenStore()
+	if err != nil {
+		return err
+	}
+	if tokens.ClientSecret, err = oidcClientSecret(); err != nil {
+		return err
+	}
+	unlock, err := lockProfile(profile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err = s.Save(profile, tokens); err != nil {
+		return fmt.Errorf("cannot save the tokens (%w)", err)
+	}
+	return nil
+}
+
+// loadTokens returns the tokens of the selected profile, moving there the secrets of the configuration file.
+func loadTokens(s store.TokenStore) (*store.Tokens, error) {
+	tokens, err := s.Load(profile)
+	if err != nil {
+		return nil, err
+	}
+	if legacy := legacySecrets(tokens); len(legacy) == 0 || !migrateSecrets {
+		return tokens, nil
+	}
+	return migrateTokens(s)
+}
+
+// legacySecrets sets the secrets still stored in the configuration file by the previous versions, returning their keys.
+func legacySecrets(tokens *store.Tokens) (keys []string) {
+	if id := viper.GetString(key(TokenID)); len(id) > 0 {
+		tokens.ID, tokens.Refresh = id, viper.GetString(key(TokenRefresh))
+		keys = append(keys, TokenID, TokenRefresh)
+	}
+	if secret := viper.GetString(key(OIDCClientSecret)); len(secret) > 0 {
+		tokens.ClientSecret = secret
+		keys = append(keys, OIDCClientSecret)
+	}
+	return
+}
+
+// migrateTokens moves the secrets of the configuration file to the token store holding the profile lock, so that a
+// concurrent execution cannot overwrite them with the tokens it refreshed meanwhile.
+func migrateTokens(s store.TokenStore) (*store.Tokens, error) {
+	unlock, err := lockProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// Another execution could have moved them while waiting for the lock
+	if err = viper.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("cannot read the configuration (%w)", err)
+	}
+	tokens, err := s.Load(profile)
+	if err != nil {
+		return nil, err
+	}
+	legacy := legacySecrets(tokens)
+	if len(legacy) == 0 {
+		return tokens, nil
+	}
+
+	logger.Info("Moving the secrets from the configuration file to the token store", zap.String("profile", profile))
+	if err = s.Save(profile, tokens); err != nil {
+		return nil, err
+	}
+	if err = removeProfileSettings(legacy...); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
@@ -0,0 +1,111 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/clastix/kubectl-login/internal/store"
+)
+
+const legacyConfigFile = `profiles:
+  default:
+    oidc:
+      clientid: kubectl
+      clientsecret: secret
+    token:
+      id: id-token
+      refresh: refresh-token
+`
+
+func TestLoadTokensMigration(t *testing.T) {
+	testCases := map[string]struct {
+		migrate bool
+	}{
+		"login":   {migrate: true},
+		"dry run": {migrate: false},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Cleanup(viper.Reset)
+			t.Cleanup(func() { migrateSecrets = true })
+			t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+			dir := t.TempDir()
+			configPath := filepath.Join(dir, "config.yaml")
+			if err := ioutil.WriteFile(configPath, []byte(legacyConfigFile), configFilePerm); err != nil {
+				t.Fatal(err)
+			}
+			viper.SetConfigFile(configPath)
+			viper.SetConfigType("yaml")
+			if err := viper.ReadInConfig(); err != nil {
+				t.Fatal(err)
+			}
+			s := store.NewFileStore(filepath.Join(dir, "tokens.json"))
+			migrateSecrets = tc.migrate
+
+			tokens, err := loadTokens(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := store.Tokens{ID: "id-token", Refresh: "refresh-token", ClientSecret: "secret"}
+			if *tokens != expected {
+				t.Errorf("expected the tokens %+v, got %+v", expected, *tokens)
+			}
+
+			stored, err := s.Load(profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadFile(configPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tc.migrate {
+				if len(stored.ID) > 0 {
+					t.Errorf("expected the token store to be left untouched, got %+v", *stored)
+				}
+				if string(b) != legacyConfigFile {
+					t.Errorf("expected the configuration file to be left untouched, got:\n%s", b)
+				}
+				return
+			}
+			if *stored != expected {
+				t.Errorf("expected the stored tokens %+v, got %+v", expected, *stored)
+			}
+			v := viper.New()
+			v.SetConfigType("yaml")
+			if err = v.ReadConfig(bytes.NewReader(b)); err != nil {
+				t.Fatal(err)
+			}
+			for _, k := range []string{TokenID, TokenRefresh, OIDCClientSecret} {
+				if v.IsSet(key(k)) {
+					t.Errorf("expected %s to be removed from the configuration file", key(k))
+				}
+			}
+			if v.GetString(key(OIDCClientID)) != "kubectl" {
+				t.Errorf("expected the other settings to be kept in the configuration file")
+			}
+		})
+	}
+}
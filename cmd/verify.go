@@ -0,0 +1,78 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/clastix/kubectl-login/internal/oidc"
+)
+
+const defaultClockSkew = time.Minute
+
+// idTokenVerifier returns the verifier of the ID tokens issued by the OIDC server the user logged in.
+func idTokenVerifier(client *oidc.HTTPClient) (*oidc.IDTokenVerifier, error) {
+	jwksURI := viper.GetString(key(TokenJWKSURI))
+	if len(jwksURI) == 0 {
+		return nil, fmt.Errorf("the OIDC server doesn't publish its keys, cannot validate the ID token: please issue the login process again")
+	}
+
+	issuer := viper.GetString(key(TokenIssuer))
+	if len(issuer) == 0 {
+		issuer = viper.GetString(key(OIDCServer))
+	}
+
+	keySet := oidc.NewKeySet(client, jwksURI, keySetCachePath(jwksURI))
+	return oidc.NewIDTokenVerifier(keySet, issuer, viper.GetString(key(OIDCClientID)), viper.GetDuration(key(OIDCClockSkew))), nil
+}
+
+// keySetCachePath returns the file caching the OIDC server keys, empty disables the cache.
+func keySetCachePath(jwksURI string) string {
+	return cachePath("jwks", jwksURI)
+}
+
+// discoveryCachePath returns the file caching the OIDC server discovery document, empty disables the cache.
+func discoveryCachePath(oidcServer string) string {
+	return cachePath("discovery", oidcServer)
+}
+
+// cachePath returns the file caching the given document for the selected profile and its TLS settings, so that a
+// document fetched trusting another CA is never used: the ones fetched skipping the TLS verification are not cached.
+func cachePath(prefix, uri string) string {
+	if viper.GetBool(key(OIDCSkipTLSVerify)) {
+		return ""
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	h := sha256.Sum256([]byte(strings.Join([]string{
+		uri,
+		profile,
+		viper.GetString(key(OIDCCertificateAuthority)),
+		viper.GetString(key(OIDCClientCertificate)),
+		viper.GetString(key(OIDCProxyURL)),
+	}, "\n")))
+	return filepath.Join(dir, "kubectl-login", fmt.Sprintf("%s-%x.json", prefix, h[:8]))
+}
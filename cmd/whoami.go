@@ -0,0 +1,229 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+
+	"github.com/clastix/kubectl-login/internal/actions"
+	"github.com/clastix/kubectl-login/internal/oidc"
+	"github.com/clastix/kubectl-login/internal/store"
+)
+
+const (
+	outputFlag         = "output"
+	checkAPIServerFlag = "check-api-server"
+	outputTable        = "table"
+	outputJSON         = "json"
+	outputYAML         = "yaml"
+)
+
+type refreshTokenStatus struct {
+	Present    bool       `json:"present"`
+	Expiration *time.Time `json:"expiration,omitempty"`
+}
+
+type apiServerIdentity struct {
+	Username string   `json:"username"`
+	UID      string   `json:"uid,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+type identity struct {
+	Profile      string             `json:"profile"`
+	Subject      string             `json:"subject"`
+	Email        string             `json:"email,omitempty"`
+	Groups       []string           `json:"groups,omitempty"`
+	Issuer       string             `json:"issuer"`
+	Audience     []string           `json:"audience"`
+	Expiration   time.Time          `json:"expiration"`
+	Expired      bool               `json:"expired"`
+	RefreshToken refreshTokenStatus `json:"refreshToken"`
+	APIServer    *apiServerIdentity `json:"apiServer,omitempty"`
+}
+
+var whoamiCmd = &cobra.Command{
+	Use:     "whoami",
+	Aliases: []string{"status"},
+	Short:   "Show the identity, groups and token lifetime of the profile",
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		output, _ := cmd.Flags().GetString(outputFlag)
+		switch output {
+		case outputTable, outputJSON, outputYAML:
+		default:
+			return fmt.Errorf("unsupported output format %s, one of %s, %s, %s", output, outputTable, outputJSON, outputYAML)
+		}
+
+		var s store.TokenStore
+		if s, err = tokenStore(); err != nil {
+			return
+		}
+		var tokens *store.Tokens
+		if tokens, err = loadTokens(s); err != nil {
+			return
+		}
+		idToken := tokens.ID
+		if len(idToken) == 0 {
+			return fmt.Errorf("the ID Token is not yet configured, please issue the login process first")
+		}
+
+		var client *oidc.HTTPClient
+		if client, err = oidcClient(); err != nil {
+			return
+		}
+
+		var verifier *oidc.IDTokenVerifier
+		if verifier, err = idTokenVerifier(client); err != nil {
+			return
+		}
+
+		var claims jwt.MapClaims
+		claims, err = verifier.Verify(idToken)
+		var expired *oidc.IDTokenExpiredError
+		if err != nil && !errors.As(err, &expired) {
+			return fmt.Errorf("the stored ID token cannot be trusted, please issue the login process again (%w)", err)
+		}
+
+		id := newIdentity(claims, tokens.Refresh)
+		id.Expired = expired != nil
+
+		if check, _ := cmd.Flags().GetBool(checkAPIServerFlag); check {
+			if id.APIServer, err = reviewIdentity(idToken); err != nil {
+				return fmt.Errorf("cannot check the identity with the Kubernetes API server (%w)", err)
+			}
+		}
+
+		return printIdentity(id, output)
+	},
+}
+
+func newIdentity(claims jwt.MapClaims, refreshToken string) *identity {
+	id := &identity{
+		Profile:  profile,
+		Audience: oidc.Audience(claims),
+		RefreshToken: refreshTokenStatus{
+			Present: len(refreshToken) > 0,
+		},
+	}
+	id.Subject, _ = claims["sub"].(string)
+	id.Email, _ = claims["email"].(string)
+	id.Issuer, _ = claims["iss"].(string)
+	id.Expiration, _ = oidc.ExpirationTime(claims)
+	if groups, ok := claims["groups"].([]interface{}); ok {
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				id.Groups = append(id.Groups, s)
+			}
+		}
+	}
+
+	// Refresh tokens are opaque to the client, although some OIDC servers issue them as JWT carrying the expiration
+	if strings.Count(refreshToken, ".") == 2 {
+		rc := jwt.MapClaims{}
+		if _, _, err := new(jwt.Parser).ParseUnverified(refreshToken, rc); err == nil {
+			if exp, ok := oidc.ExpirationTime(rc); ok {
+				id.RefreshToken.Expiration = &exp
+			}
+		}
+	}
+
+	return id
+}
+
+// reviewIdentity asks the Kubernetes API server which user it authenticates with the given token.
+func reviewIdentity(token string) (*apiServerIdentity, error) {
+	cluster, err := clusterEntry()
+	if err != nil {
+		return nil, err
+	}
+	config, err := clusterRestConfig(cluster, token)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := actions.NewSelfSubjectReview(logger, config).Handle()
+	if err != nil {
+		return nil, err
+	}
+
+	return &apiServerIdentity{
+		Username: user.Username,
+		UID:      user.UID,
+		Groups:   user.Groups,
+	}, nil
+}
+
+func printIdentity(id *identity, output string) error {
+	switch output {
+	case outputJSON:
+		b, err := json.MarshalIndent(id, "", "  ")
+		if err != nil {
+			return fmt.Errorf("cannot encode the identity (%w)", err)
+		}
+		fmt.Println(string(b))
+	case outputYAML:
+		b, err := yaml.Marshal(id)
+		if err != nil {
+			return fmt.Errorf("cannot encode the identity (%w)", err)
+		}
+		fmt.Print(string(b))
+	default:
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		lifetime := fmt.Sprintf("%s (%s left)", id.Expiration.Format(time.RFC3339), time.Until(id.Expiration).Round(time.Second))
+		if id.Expired {
+			lifetime = fmt.Sprintf("%s (expired)", id.Expiration.Format(time.RFC3339))
+		}
+		refresh := "absent"
+		if id.RefreshToken.Present {
+			refresh = "present"
+			if exp := id.RefreshToken.Expiration; exp != nil {
+				refresh = fmt.Sprintf("present, expiring at %s", exp.Format(time.RFC3339))
+			}
+		}
+		_, _ = fmt.Fprintf(w, "PROFILE\t%s\n", id.Profile)
+		_, _ = fmt.Fprintf(w, "SUBJECT\t%s\n", id.Subject)
+		_, _ = fmt.Fprintf(w, "EMAIL\t%s\n", id.Email)
+		_, _ = fmt.Fprintf(w, "GROUPS\t%s\n", strings.Join(id.Groups, ", "))
+		_, _ = fmt.Fprintf(w, "ISSUER\t%s\n", id.Issuer)
+		_, _ = fmt.Fprintf(w, "AUDIENCE\t%s\n", strings.Join(id.Audience, ", "))
+		_, _ = fmt.Fprintf(w, "EXPIRATION\t%s\n", lifetime)
+		_, _ = fmt.Fprintf(w, "REFRESH TOKEN\t%s\n", refresh)
+		if id.APIServer != nil {
+			_, _ = fmt.Fprintf(w, "API SERVER USERNAME\t%s\n", id.APIServer.Username)
+			_, _ = fmt.Fprintf(w, "API SERVER GROUPS\t%s\n", strings.Join(id.APIServer.Groups, ", "))
+		}
+		return w.Flush()
+	}
+
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(whoamiCmd)
+	whoamiCmd.Flags().StringP(outputFlag, "o", outputTable, fmt.Sprintf("Output format, one of %s, %s, %s", outputTable, outputJSON, outputYAML))
+	whoamiCmd.Flags().Bool(checkAPIServerFlag, false, "Confirm the identity seen by the Kubernetes API server with a SelfSubjectReview, or a TokenReview for older clusters")
+}
@@ -0,0 +1,67 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package actions
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/rest"
+)
+
+type APIServerVersion struct {
+	logger *zap.Logger
+	config *rest.Config
+}
+
+func NewAPIServerVersion(logger *zap.Logger, config *rest.Config) *APIServerVersion {
+	return &APIServerVersion{
+		logger: logger,
+		config: config,
+	}
+}
+
+// Handle probes the /version endpoint, returning the version when readable anonymously.
+func (r APIServerVersion) Handle() (string, error) {
+	client, err := kubernetesClient(r.logger, r.config)
+	if err != nil {
+		return "", err
+	}
+
+	var res *http.Response
+	if res, err = client.Get(r.config.Host + "/version"); err != nil {
+		r.logger.Error("The Kubernetes API server returned an error", zap.Error(err), zap.String("host", r.config.Host))
+		return "", err
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode != http.StatusOK {
+		r.logger.Debug("The Kubernetes API server version is not readable", zap.String("status", res.Status))
+		return "", nil
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	info := &version.Info{}
+	if err = json.Unmarshal(b, info); err != nil {
+		r.logger.Debug("Cannot unmarshal the Kubernetes API server version", zap.Error(err), zap.ByteString("body", b))
+		return "", nil
+	}
+
+	return info.GitVersion, nil
+}
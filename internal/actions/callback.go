@@ -0,0 +1,92 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package actions
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+type StateMismatchError struct {
+	missing bool
+}
+
+func (r StateMismatchError) Error() string {
+	if r.missing {
+		return "the authorization response is missing the state parameter, it cannot be bound to this login attempt (possible CSRF)"
+	}
+	return "the state parameter of the authorization response does not match this login attempt (possible CSRF)"
+}
+
+func NewStateMismatchError(missing bool) error {
+	return &StateMismatchError{missing: missing}
+}
+
+// parseCallback extracts the authorization code from the redirect query string, verifying the state.
+func parseCallback(logger *zap.Logger, qs url.Values, state string) (code string, err error) {
+	if len(qs.Get("error")) > 0 {
+		logger.Error("The authorization server returned an error", zap.String("error", qs.Get("error")), zap.String("description", qs.Get("error_description")))
+		return "", fmt.Errorf("server returned the error %s", qs.Get("error"))
+	}
+
+	switch {
+	case len(qs.Get("state")) == 0:
+		return "", NewStateMismatchError(true)
+	case subtle.ConstantTimeCompare([]byte(qs.Get("state")), []byte(state)) != 1:
+		logger.Error("Received state does not match", zap.String("expected", state), zap.String("received", qs.Get("state")))
+		return "", NewStateMismatchError(false)
+	}
+
+	if code = qs.Get("code"); len(code) == 0 {
+		return "", fmt.Errorf("the authorization response is missing the code")
+	}
+
+	return code, nil
+}
+
+type CodeInput struct {
+	logger *zap.Logger
+	state  string
+}
+
+func NewCodeInput(logger *zap.Logger, state string) *CodeInput {
+	return &CodeInput{
+		logger: logger,
+		state:  state,
+	}
+}
+
+// Handle accepts either the bare verification code or the full response URL, verifying its state: the bare code
+// displayed by the out-of-band flow carries no state to be checked, it's bound to this login attempt by the PKCE code
+// verifier only, redeemed along with it.
+func (r CodeInput) Handle(input string) (code string, err error) {
+	if input = strings.TrimSpace(input); len(input) == 0 {
+		return "", fmt.Errorf("the verification code cannot be empty")
+	}
+
+	u, err := url.Parse(input)
+	if err != nil || len(u.Scheme) == 0 || len(u.RawQuery) == 0 {
+		r.logger.Debug("Input is not a response URL, handling it as the verification code")
+		return input, nil
+	}
+
+	return parseCallback(r.logger, u.Query(), r.state)
+}
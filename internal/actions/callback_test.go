@@ -0,0 +1,179 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package actions
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+const testState = "d2fe1c9a7b"
+
+func TestParseCallback(t *testing.T) {
+	testCases := map[string]struct {
+		query   url.Values
+		code    string
+		missing *bool
+		wantErr bool
+	}{
+		"matching state": {
+			query: url.Values{"code": {"abc"}, "state": {testState}},
+			code:  "abc",
+		},
+		"mismatched state": {
+			query:   url.Values{"code": {"abc"}, "state": {"forged"}},
+			missing: new(bool),
+			wantErr: true,
+		},
+		"missing state": {
+			query:   url.Values{"code": {"abc"}},
+			missing: func() *bool { b := true; return &b }(),
+			wantErr: true,
+		},
+		"error response": {
+			query:   url.Values{"error": {"access_denied"}, "state": {testState}},
+			wantErr: true,
+		},
+		"missing code": {
+			query:   url.Values{"state": {testState}},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			code, err := parseCallback(zap.NewNop(), tc.query, testState)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if code != tc.code {
+				t.Errorf("expected the code %q, got %q", tc.code, code)
+			}
+			if tc.missing != nil {
+				var stateErr *StateMismatchError
+				if !errors.As(err, &stateErr) {
+					t.Fatalf("expected a StateMismatchError, got %v", err)
+				}
+				if stateErr.missing != *tc.missing {
+					t.Errorf("expected the missing state %t, got %t", *tc.missing, stateErr.missing)
+				}
+			}
+		})
+	}
+}
+
+func TestCodeInput(t *testing.T) {
+	testCases := map[string]struct {
+		input   string
+		code    string
+		wantErr bool
+	}{
+		"bare code": {
+			input: " 4/0AX4XfWh ",
+			code:  "4/0AX4XfWh",
+		},
+		"response URL": {
+			input: "http://127.0.0.1:8000/callback?code=abc&state=" + testState,
+			code:  "abc",
+		},
+		"response URL with forged state": {
+			input:   "http://127.0.0.1:8000/callback?code=abc&state=forged",
+			wantErr: true,
+		},
+		"response URL without state": {
+			input:   "http://127.0.0.1:8000/callback?code=abc",
+			wantErr: true,
+		},
+		"empty input": {
+			input:   "  ",
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			code, err := NewCodeInput(zap.NewNop(), testState).Handle(tc.input)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if code != tc.code {
+				t.Errorf("expected the code %q, got %q", tc.code, code)
+			}
+		})
+	}
+}
+
+func TestLoopbackServer(t *testing.T) {
+	testCases := map[string]struct {
+		query   url.Values
+		status  int
+		code    string
+		wantErr bool
+	}{
+		"valid response": {
+			query:  url.Values{"code": {"abc"}, "state": {testState}},
+			status: http.StatusOK,
+			code:   "abc",
+		},
+		"forged state": {
+			query:   url.Values{"code": {"abc"}, "state": {"forged"}},
+			status:  http.StatusBadRequest,
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			server, err := NewLoopbackServer(zap.NewNop(), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			type result struct {
+				code string
+				err  error
+			}
+			results := make(chan result, 1)
+			go func() {
+				code, err := server.Handle(testState)
+				results <- result{code: code, err: err}
+			}()
+
+			// The listener is bound upon creation: the request is queued until served
+			res, err := http.Get(server.RedirectURI() + "?" + tc.query.Encode())
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = res.Body.Close()
+			if res.StatusCode != tc.status {
+				t.Errorf("expected the status %d, got %d", tc.status, res.StatusCode)
+			}
+
+			r := <-results
+			if (r.err != nil) != tc.wantErr {
+				t.Fatalf("unexpected error: %v", r.err)
+			}
+			if r.code != tc.code {
+				t.Errorf("expected the code %q, got %q", tc.code, r.code)
+			}
+		})
+	}
+}
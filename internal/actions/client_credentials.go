@@ -0,0 +1,63 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package actions
+
+import (
+	"fmt"
+	"net/url"
+
+	"go.uber.org/zap"
+
+	"github.com/clastix/kubectl-login/internal/oidc"
+)
+
+type ClientCredentials struct {
+	logger        *zap.Logger
+	client        *oidc.HTTPClient
+	auth          *oidc.ClientAuthentication
+	tokenEndpoint string
+}
+
+func NewClientCredentials(logger *zap.Logger, tokenEndpoint string, auth *oidc.ClientAuthentication, httpClient *oidc.HTTPClient) *ClientCredentials {
+	return &ClientCredentials{
+		logger:        logger,
+		client:        httpClient,
+		auth:          auth,
+		tokenEndpoint: tokenEndpoint,
+	}
+}
+
+// Handle requests an ID token for the client itself, no refresh token is expected.
+func (r ClientCredentials) Handle() (token string, err error) {
+	r.logger.Info("Requesting tokens with the Client Credentials", zap.String("clientID", r.auth.ClientID()))
+
+	d := url.Values{}
+	d.Add("grant_type", "client_credentials")
+	d.Add("scope", "openid")
+
+	var t *tokenResponse
+	if t, err = requestToken(r.logger, &r.client.Client, r.auth, r.tokenEndpoint, d, true); err != nil {
+		return
+	}
+
+	// Access tokens are not meant to be validated by the client, nor accepted by the Kubernetes OIDC authenticator
+	if len(t.IDToken) == 0 {
+		return "", fmt.Errorf("the OIDC server issued no ID token for the client credentials, the openid scope must be granted to the client")
+	}
+
+	return t.IDToken, nil
+}
@@ -0,0 +1,100 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package actions
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	clusterInfoPath = "/api/v1/namespaces/kube-public/configmaps/cluster-info"
+	clusterInfoKey  = "kubeconfig"
+)
+
+type ClusterInfo struct {
+	logger *zap.Logger
+	config *rest.Config
+}
+
+// NewClusterInfo returns the action reading the cluster-info ConfigMap published by kubeadm.
+func NewClusterInfo(logger *zap.Logger, config *rest.Config) *ClusterInfo {
+	return &ClusterInfo{
+		logger: logger,
+		config: config,
+	}
+}
+
+// Handle returns the certificate authority of the cluster-info ConfigMap, not to be trusted as is.
+func (r ClusterInfo) Handle() ([]byte, error) {
+	client, err := kubernetesClient(r.logger, r.config)
+	if err != nil {
+		return nil, err
+	}
+
+	var res *http.Response
+	if res, err = client.Get(strings.TrimRight(r.config.Host, "/") + clusterInfoPath); err != nil {
+		r.logger.Error("The Kubernetes API server returned an error", zap.Error(err), zap.String("host", r.config.Host))
+		return nil, err
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	var b []byte
+	if b, err = ioutil.ReadAll(res.Body); err != nil {
+		return nil, fmt.Errorf("cannot read response body")
+	}
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("the Kubernetes API server doesn't publish the kube-public/cluster-info ConfigMap")
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, fmt.Errorf("the Kubernetes API server doesn't allow reading the kube-public/cluster-info ConfigMap anonymously")
+	default:
+		return nil, fmt.Errorf("the Kubernetes API server returned %s", res.Status)
+	}
+
+	cm := &corev1.ConfigMap{}
+	if err = json.Unmarshal(b, cm); err != nil {
+		r.logger.Error("Cannot unmarshal JSON response", zap.Error(err))
+		return nil, fmt.Errorf("the response body is not a valid JSON")
+	}
+	data, ok := cm.Data[clusterInfoKey]
+	if !ok {
+		return nil, fmt.Errorf("the cluster-info ConfigMap is missing the %s key", clusterInfoKey)
+	}
+	cfg, err := clientcmd.Load([]byte(data))
+	if err != nil {
+		return nil, fmt.Errorf("the cluster-info ConfigMap holds an invalid kubeconfig (%w)", err)
+	}
+	// kubeadm publishes a single cluster, with an empty name
+	for name, c := range cfg.Clusters {
+		if len(c.CertificateAuthorityData) > 0 {
+			r.logger.Debug("Certificate authority found in the cluster-info ConfigMap", zap.String("cluster", name), zap.String("server", c.Server))
+			return c.CertificateAuthorityData, nil
+		}
+	}
+
+	return nil, fmt.Errorf("the cluster-info ConfigMap doesn't hold any certificate authority")
+}
@@ -28,20 +28,22 @@ import (
 )
 
 type AuthenticationURI struct {
-	logger                                   *zap.Logger
-	authEndpoint, oidcClientID, codeVerifier string
+	logger                                                       *zap.Logger
+	authEndpoint, oidcClientID, codeVerifier, nonce, redirectURI string
 }
 
-func NewAuthenticationURI(logger *zap.Logger, oidcClientID, codeVerifier string, configuration *OIDCResponse) *AuthenticationURI {
+func NewAuthenticationURI(logger *zap.Logger, oidcClientID, codeVerifier, nonce, redirectURI string, configuration *OIDCResponse) *AuthenticationURI {
 	return &AuthenticationURI{
 		logger:       logger,
 		authEndpoint: configuration.AuthorizationEndpoint,
 		oidcClientID: oidcClientID,
 		codeVerifier: codeVerifier,
+		nonce:        nonce,
+		redirectURI:  redirectURI,
 	}
 }
 
-func (r AuthenticationURI) Handle() (authURI string, err error) {
+func (r AuthenticationURI) Handle() (authURI, state string, err error) {
 	r.logger.Info("Creating authorization URI")
 
 	b := make([]byte, 32)
@@ -51,7 +53,7 @@ func (r AuthenticationURI) Handle() (authURI string, err error) {
 	}
 	b64State := base64.URLEncoding.EncodeToString(b)
 	re := regexp.MustCompile(`[\W_]`)
-	state := re.ReplaceAllString(b64State, "")
+	state = re.ReplaceAllString(b64State, "")
 
 	hash := sha256.Sum256([]byte(r.codeVerifier))
 	codeChallenge := base64.RawURLEncoding.EncodeToString(hash[:])
@@ -67,9 +69,10 @@ func (r AuthenticationURI) Handle() (authURI string, err error) {
 	qs := u.Query()
 	qs.Set("response_type", "code")
 	qs.Set("client_id", r.oidcClientID)
-	qs.Set("redirect_uri", "urn:ietf:wg:oauth:2.0:oob")
+	qs.Set("redirect_uri", r.redirectURI)
 	qs.Set("scope", "openid+profile+groups+offline_access")
 	qs.Set("state", state)
+	qs.Set("nonce", r.nonce)
 	qs.Set("prompt", "consent")
 	qs.Set("code_challenge", codeChallenge)
 	qs.Set("code_challenge_method", codeChallengeMethod)
@@ -87,5 +90,5 @@ func (r AuthenticationURI) Handle() (authURI string, err error) {
 		}(qs.Encode()),
 	}
 
-	return authURL.String(), nil
+	return authURL.String(), state, nil
 }
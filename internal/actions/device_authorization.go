@@ -0,0 +1,185 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package actions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/clastix/kubectl-login/internal/oidc"
+)
+
+const (
+	deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+	// Default polling interval according to RFC 8628, section 3.2
+	deviceDefaultInterval = 5 * time.Second
+)
+
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+	Error                   string `json:"error"`
+	ErrorDescription        string `json:"error_description"`
+	ErrorURI                string `json:"error_uri"`
+}
+
+type DeviceAuthorization struct {
+	logger             *zap.Logger
+	client             *oidc.HTTPClient
+	auth               *oidc.ClientAuthentication
+	deviceAuthEndpoint string
+}
+
+func NewDeviceAuthorization(logger *zap.Logger, auth *oidc.ClientAuthentication, configuration *OIDCResponse, httpClient *oidc.HTTPClient) *DeviceAuthorization {
+	return &DeviceAuthorization{
+		logger:             logger,
+		client:             httpClient,
+		auth:               auth,
+		deviceAuthEndpoint: configuration.DeviceAuthorizationEndpoint,
+	}
+}
+
+func (r DeviceAuthorization) Handle() (response *DeviceAuthorizationResponse, err error) {
+	r.logger.Info("Requesting the device authorization", zap.String("deviceAuthorizationEndpoint", r.deviceAuthEndpoint))
+
+	if len(r.deviceAuthEndpoint) == 0 {
+		return nil, fmt.Errorf("the OIDC server doesn't support the Device Authorization Grant")
+	}
+
+	d := url.Values{}
+	d.Add("scope", "openid profile groups offline_access")
+
+	var req *http.Request
+	if req, err = r.auth.NewRequest(r.deviceAuthEndpoint, d); err != nil {
+		r.logger.Error("Cannot create the device authorization request", zap.Error(err))
+		return nil, fmt.Errorf("cannot authenticate the client (%w)", err)
+	}
+
+	var res *http.Response
+	if res, err = r.client.Do(req); err != nil {
+		r.logger.Error("The server returned an error", zap.Error(err), zap.String("uri", r.deviceAuthEndpoint))
+		return nil, oidc.NewRequestError(r.deviceAuthEndpoint, err)
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	var b []byte
+	if b, err = ioutil.ReadAll(res.Body); err != nil {
+		r.logger.Error("Cannot read response body", zap.Error(err))
+		return nil, fmt.Errorf("cannot read response body")
+	}
+	response = &DeviceAuthorizationResponse{}
+	if err = json.Unmarshal(b, response); err != nil {
+		r.logger.Error("Cannot unmarshal JSON response", zap.Error(err))
+		if res.StatusCode != http.StatusOK {
+			return nil, oidc.NewHTTPError(res.StatusCode, res.Status, r.deviceAuthEndpoint)
+		}
+		return nil, fmt.Errorf("the response body is not a valid JSON")
+	}
+	if len(response.Error) > 0 {
+		return nil, oidc.NewOAuthError(res.StatusCode, response.Error, response.ErrorDescription, response.ErrorURI)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, oidc.NewHTTPError(res.StatusCode, res.Status, r.deviceAuthEndpoint)
+	}
+	if len(response.DeviceCode) == 0 || len(response.UserCode) == 0 {
+		return nil, fmt.Errorf("the device authorization response is missing the device or user code")
+	}
+
+	return response, nil
+}
+
+type DeviceToken struct {
+	logger        *zap.Logger
+	client        *oidc.HTTPClient
+	auth          *oidc.ClientAuthentication
+	tokenEndpoint string
+	authorization *DeviceAuthorizationResponse
+	// after waits for the polling interval and the device code expiration, replaced by the tests
+	after func(time.Duration) <-chan time.Time
+}
+
+func NewDeviceToken(logger *zap.Logger, tokenEndpoint string, authorization *DeviceAuthorizationResponse, auth *oidc.ClientAuthentication, httpClient *oidc.HTTPClient) *DeviceToken {
+	return &DeviceToken{
+		logger:        logger,
+		client:        httpClient,
+		auth:          auth,
+		tokenEndpoint: tokenEndpoint,
+		authorization: authorization,
+		after:         time.After,
+	}
+}
+
+// Handle polls the token endpoint until the user completes the authorization or the device code expires.
+func (r DeviceToken) Handle() (idToken, refreshToken string, err error) {
+	d := url.Values{}
+	d.Add("grant_type", deviceCodeGrantType)
+	d.Add("device_code", r.authorization.DeviceCode)
+
+	interval := deviceDefaultInterval
+	if r.authorization.Interval > 0 {
+		interval = time.Duration(r.authorization.Interval) * time.Second
+	}
+	var deadline <-chan time.Time
+	if r.authorization.ExpiresIn > 0 {
+		deadline = r.after(time.Duration(r.authorization.ExpiresIn) * time.Second)
+	}
+
+	for {
+		select {
+		case <-deadline:
+			return "", "", fmt.Errorf("the device code has expired before the authorization was completed")
+		case <-r.after(interval):
+		}
+
+		r.logger.Debug("Polling the token endpoint", zap.Duration("interval", interval))
+
+		var t *tokenResponse
+		if t, err = requestToken(r.logger, &r.client.Client, r.auth, r.tokenEndpoint, d, true); err == nil {
+			return t.IDToken, t.RefreshToken, nil
+		}
+
+		var oauthErr *oidc.OAuthError
+		if !errors.As(err, &oauthErr) {
+			return "", "", err
+		}
+		switch oauthErr.Code {
+		case oidc.ErrorAuthorizationPending:
+			continue
+		case oidc.ErrorSlowDown:
+			interval += deviceDefaultInterval
+			continue
+		case oidc.ErrorExpiredToken:
+			return "", "", fmt.Errorf("the device code has expired before the authorization was completed")
+		case oidc.ErrorAccessDenied:
+			return "", "", fmt.Errorf("the authorization request has been denied")
+		default:
+			r.logger.Error("Token retrieval failed", zap.Error(err))
+			return "", "", err
+		}
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package actions
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/clastix/kubectl-login/internal/oidc"
+)
+
+// deviceTokenEndpoint answers the polling requests with the given sequence of OAuth errors, then issues the tokens.
+type deviceTokenEndpoint struct {
+	t      *testing.T
+	mu     sync.Mutex
+	errors []string
+	hits   int
+}
+
+func (e *deviceTokenEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if r.PostFormValue("grant_type") != deviceCodeGrantType || r.PostFormValue("device_code") != "device-code" {
+		e.t.Errorf("unexpected token request %v", r.PostForm)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if e.hits++; e.hits <= len(e.errors) {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": e.errors[e.hits-1]})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]string{"id_token": "id-token", "refresh_token": "refresh-token"})
+}
+
+func TestDeviceTokenPolling(t *testing.T) {
+	testCases := map[string]struct {
+		interval int64
+		errors   []string
+		expired  bool
+		waits    []time.Duration
+		err      string
+	}{
+		"authorization pending": {
+			interval: 2,
+			errors:   []string{oidc.ErrorAuthorizationPending, oidc.ErrorAuthorizationPending},
+			waits:    []time.Duration{2 * time.Second, 2 * time.Second, 2 * time.Second},
+		},
+		"default interval": {
+			errors: []string{oidc.ErrorAuthorizationPending},
+			waits:  []time.Duration{5 * time.Second, 5 * time.Second},
+		},
+		"slow down": {
+			interval: 5,
+			errors:   []string{oidc.ErrorSlowDown, oidc.ErrorAuthorizationPending, oidc.ErrorSlowDown},
+			waits:    []time.Duration{5 * time.Second, 10 * time.Second, 10 * time.Second, 15 * time.Second},
+		},
+		"expired token": {
+			errors: []string{oidc.ErrorAuthorizationPending, oidc.ErrorExpiredToken},
+			waits:  []time.Duration{5 * time.Second, 5 * time.Second},
+			err:    "the device code has expired",
+		},
+		"access denied": {
+			errors: []string{oidc.ErrorAccessDenied},
+			waits:  []time.Duration{5 * time.Second},
+			err:    "has been denied",
+		},
+		"other error": {
+			errors: []string{oidc.ErrorInvalidGrant},
+			waits:  []time.Duration{5 * time.Second},
+			err:    oidc.ErrorInvalidGrant,
+		},
+		"expired before the authorization": {
+			expired: true,
+			err:     "the device code has expired",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			endpoint := &deviceTokenEndpoint{t: t, errors: tc.errors}
+			srv := httptest.NewServer(endpoint)
+			defer srv.Close()
+
+			auth, err := oidc.NewClientAuthentication(oidc.ClientAuthNone, "kubectl", "", "", "", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			authorization := &DeviceAuthorizationResponse{DeviceCode: "device-code", UserCode: "ABCD-EFGH", ExpiresIn: 600, Interval: tc.interval}
+			r := NewDeviceToken(zap.NewNop(), srv.URL, authorization, auth, &oidc.HTTPClient{Client: http.Client{}})
+
+			// Waiting for nothing, only recording the polling intervals
+			var waits []time.Duration
+			expiration := true
+			r.after = func(d time.Duration) <-chan time.Time {
+				c := make(chan time.Time, 1)
+				if expiration {
+					expiration = false
+					if d != 600*time.Second {
+						t.Errorf("expected the device code to expire in 10m0s, got %s", d)
+					}
+					if !tc.expired {
+						return nil
+					}
+					c <- time.Now()
+					return c
+				}
+				waits = append(waits, d)
+				if tc.expired {
+					return nil
+				}
+				c <- time.Now()
+				return c
+			}
+
+			id, refresh, err := r.Handle()
+			if len(tc.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected the error %q, got %v", tc.err, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if id != "id-token" || refresh != "refresh-token" {
+				t.Errorf("expected the issued tokens, got %q and %q", id, refresh)
+			}
+
+			if tc.expired {
+				if endpoint.hits != 0 {
+					t.Errorf("expected no token request, got %d", endpoint.hits)
+				}
+				return
+			}
+			if !reflect.DeepEqual(waits, tc.waits) {
+				t.Errorf("expected the polling intervals %v, got %v", tc.waits, waits)
+			}
+		})
+	}
+}
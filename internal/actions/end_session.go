@@ -0,0 +1,74 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package actions
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"go.uber.org/zap"
+
+	"github.com/clastix/kubectl-login/internal/oidc"
+)
+
+type EndSession struct {
+	logger             *zap.Logger
+	client             *oidc.HTTPClient
+	endSessionEndpoint string
+	clientID, idToken  string
+}
+
+func NewEndSession(logger *zap.Logger, endSessionEndpoint, clientID, idToken string, httpClient *oidc.HTTPClient) *EndSession {
+	return &EndSession{
+		logger:             logger,
+		client:             httpClient,
+		endSessionEndpoint: endSessionEndpoint,
+		clientID:           clientID,
+		idToken:            idToken,
+	}
+}
+
+// Handle ends the session of the user at the OIDC server (OpenID Connect RP-Initiated Logout 1.0).
+func (r EndSession) Handle() (err error) {
+	r.logger.Info("Ending the session at the OIDC server", zap.String("endSessionEndpoint", r.endSessionEndpoint))
+
+	var u *url.URL
+	if u, err = url.Parse(r.endSessionEndpoint); err != nil {
+		r.logger.Error("Cannot end the session due to non well-formed endpoint", zap.Error(err), zap.String("endSessionEndpoint", r.endSessionEndpoint))
+		return fmt.Errorf("non well-formed endpoint")
+	}
+	q := u.Query()
+	q.Set("client_id", r.clientID)
+	if len(r.idToken) > 0 {
+		q.Set("id_token_hint", r.idToken)
+	}
+	u.RawQuery = q.Encode()
+
+	var res *http.Response
+	if res, err = r.client.Get(u.String()); err != nil {
+		r.logger.Error("The server returned an error", zap.Error(err), zap.String("uri", r.endSessionEndpoint))
+		return fmt.Errorf("the server returned an error")
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("the server returned %s", res.Status)
+	}
+
+	return nil
+}
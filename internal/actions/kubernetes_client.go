@@ -0,0 +1,36 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package actions
+
+import (
+	"fmt"
+	"net/http"
+
+	"go.uber.org/zap"
+	"k8s.io/client-go/rest"
+)
+
+// kubernetesClient returns the HTTP client of the Kubernetes API server, with the TLS settings and credentials of the
+// given configuration as kubectl uses them.
+func kubernetesClient(logger *zap.Logger, config *rest.Config) (*http.Client, error) {
+	transport, err := rest.TransportFor(config)
+	if err != nil {
+		logger.Error("Cannot create the Kubernetes API server transport", zap.Error(err))
+		return nil, fmt.Errorf("cannot configure the Kubernetes API server client (%w)", err)
+	}
+	return &http.Client{Transport: transport, Timeout: config.Timeout}, nil
+}
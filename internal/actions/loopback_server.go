@@ -0,0 +1,127 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package actions
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// OOBRedirectURI is the out-of-band redirect URI, the authorization server displays the code to be copy-pasted.
+	OOBRedirectURI = "urn:ietf:wg:oauth:2.0:oob"
+
+	// LoopbackTimeout is how long the user is waited for completing the login in the browser.
+	LoopbackTimeout = 5 * time.Minute
+
+	loopbackAddress      = "127.0.0.1"
+	loopbackCallbackPath = "/callback"
+)
+
+const loopbackPage = `<!DOCTYPE html>
+<html>
+<head><title>kubectl-login</title></head>
+<body>
+<h1>%s</h1>
+<p>%s</p>
+</body>
+</html>
+`
+
+type callbackResult struct {
+	code string
+	err  error
+}
+
+type LoopbackServer struct {
+	logger   *zap.Logger
+	listener net.Listener
+}
+
+// NewLoopbackServer binds the redirect listener on the loopback interface, a zero port lets the system pick a free one.
+func NewLoopbackServer(logger *zap.Logger, port int) (*LoopbackServer, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort(loopbackAddress, strconv.Itoa(port)))
+	if err != nil {
+		logger.Error("Cannot listen on the loopback interface", zap.Int("port", port), zap.Error(err))
+		return nil, fmt.Errorf("cannot listen on %s:%d", loopbackAddress, port)
+	}
+
+	return &LoopbackServer{
+		logger:   logger,
+		listener: listener,
+	}, nil
+}
+
+func (r LoopbackServer) RedirectURI() string {
+	return fmt.Sprintf("http://%s%s", r.listener.Addr().String(), loopbackCallbackPath)
+}
+
+// Handle serves the redirect callback until the code issued for the given state is received.
+func (r LoopbackServer) Handle(state string) (code string, err error) {
+	r.logger.Info("Waiting for the authorization code on the loopback interface", zap.String("redirectURI", r.RedirectURI()))
+
+	results := make(chan callbackResult, 1)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(loopbackCallbackPath, func(w http.ResponseWriter, req *http.Request) {
+		var res callbackResult
+		res.code, res.err = parseCallback(r.logger, req.URL.Query(), state)
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if res.err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = fmt.Fprintf(w, loopbackPage, "Login failed", html.EscapeString(res.err.Error()))
+		} else {
+			_, _ = fmt.Fprintf(w, loopbackPage, "Login succeeded", "You can close this window and return to the terminal.")
+		}
+
+		select {
+		case results <- res:
+		default:
+		}
+	})
+
+	server := &http.Server{Handler: mux}
+	go func() {
+		if e := server.Serve(r.listener); e != nil && e != http.ErrServerClosed {
+			r.logger.Error("The loopback server stopped unexpectedly", zap.Error(e))
+			select {
+			case results <- callbackResult{err: fmt.Errorf("the loopback server stopped unexpectedly")}:
+			default:
+			}
+		}
+	}()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	}()
+
+	select {
+	case res := <-results:
+		return res.code, res.err
+	case <-time.After(LoopbackTimeout):
+		return "", fmt.Errorf("timed out waiting for the authorization code")
+	}
+}
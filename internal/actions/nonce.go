@@ -0,0 +1,45 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package actions
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+
+	"go.uber.org/zap"
+)
+
+type Nonce struct {
+	logger *zap.Logger
+}
+
+func NewNonce(logger *zap.Logger) *Nonce {
+	return &Nonce{logger: logger}
+}
+
+// Handle generates the nonce binding the ID token to this login attempt, mitigating replay attacks.
+func (r Nonce) Handle() (nonce string, err error) {
+	r.logger.Info("Generating the nonce")
+
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		r.logger.Error("Cannot read random generate data", zap.Error(err))
+		return
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -21,6 +21,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -29,43 +31,96 @@ import (
 
 const (
 	codeChallengeMethod = "S256"
+	// Lifetime of the cached discovery document when the OIDC server doesn't set the Cache-Control header
+	defaultDiscoveryMaxAge = 24 * time.Hour
 )
 
 type OIDCResponse struct {
+	Issuer                        string   `json:"issuer"`
 	AuthorizationEndpoint         string   `json:"authorization_endpoint"`
 	TokenEndpoint                 string   `json:"token_endpoint"`
 	IntrospectionEndpoint         string   `json:"introspection_endpoint"`
 	UserInfoEndpoint              string   `json:"userinfo_endpoint"`
 	EndSessionEndpoint            string   `json:"end_session_endpoint"`
+	RevocationEndpoint            string   `json:"revocation_endpoint"`
+	DeviceAuthorizationEndpoint   string   `json:"device_authorization_endpoint"`
+	JWKSURI                       string   `json:"jwks_uri"`
 	GrantTypesSupported           []string `json:"grant_types_supported"`
 	ResponseTypesSupported        []string `json:"response_types_supported"`
 	ResponseModesSupported        []string `json:"response_modes_supported"`
 	ClaimsSupported               []string `json:"claims_supported"`
 	ScopesSupported               []string `json:"scopes_supported"`
 	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported"`
+	TokenEndpointAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
 	Error                         string   `json:"error"`
+	ErrorDescription              string   `json:"error_description"`
+	ErrorURI                      string   `json:"error_uri"`
+}
+
+// IssuerMismatchError is returned when the discovered issuer differs from the configured OIDC server.
+type IssuerMismatchError struct {
+	configured, advertised string
+}
+
+func (r IssuerMismatchError) Error() string {
+	return fmt.Sprintf("The OIDC server advertises the issuer %q instead of the configured %q, set the OIDC server to the exact issuer URL", r.advertised, r.configured)
+}
+
+func NewIssuerMismatchError(configured, advertised string) error {
+	return &IssuerMismatchError{configured: configured, advertised: advertised}
+}
+
+// SupportsCodeChallengeMethod reports whether the PKCE code challenge method is supported, assumed when not advertised.
+func (r OIDCResponse) SupportsCodeChallengeMethod() bool {
+	return len(r.CodeChallengeMethodsSupported) == 0 || contains(r.CodeChallengeMethodsSupported, codeChallengeMethod)
+}
+
+// SupportsGrantType reports whether the given grant type is supported, assumed when not advertised.
+func (r OIDCResponse) SupportsGrantType(grantType string) bool {
+	return len(r.GrantTypesSupported) == 0 || contains(r.GrantTypesSupported, grantType)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
 
 type PKCELogin struct {
-	logger *zap.Logger
-	client *oidc.HTTPClient
+	logger    *zap.Logger
+	client    *oidc.HTTPClient
+	cachePath string
 }
 
-func NewOIDCConfiguration(logger *zap.Logger, oidcClient *oidc.HTTPClient) *PKCELogin {
+// NewOIDCConfiguration returns the action retrieving the discovery document, cached at the given path when set.
+func NewOIDCConfiguration(logger *zap.Logger, oidcClient *oidc.HTTPClient, cachePath string) *PKCELogin {
 	return &PKCELogin{
-		logger: logger,
-		client: oidcClient,
+		logger:    logger,
+		client:    oidcClient,
+		cachePath: cachePath,
 	}
 }
 
 func (r PKCELogin) Handle(oidcServer string) (response *OIDCResponse, err error) {
+	if response = r.cached(oidcServer); response != nil {
+		r.logger.Info("Using the cached OIDC configuration", zap.String("OIDCServer", oidcServer))
+		return response, nil
+	}
+
 	r.logger.Info("Getting OIDC configuration from the server", zap.String("OIDCServer", oidcServer))
 
+	var req *http.Request
+	// The issuer could end with a slash, removed before appending the well-known path (OpenID Connect Discovery, 4.1)
+	if req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("%s/.well-known/openid-configuration", strings.TrimSuffix(oidcServer, "/")), nil); err != nil {
+		return
+	}
 	var res *http.Response
-	res, err = r.client.Get(fmt.Sprintf("%s/.well-known/openid-configuration", oidcServer))
-	if err != nil {
+	if res, err = r.client.Do(oidc.RetryServerErrors(req)); err != nil {
 		r.logger.Error("the server returned an error", zap.String("OIDCServer", oidcServer), zap.Error(err))
-		err = fmt.Errorf("the server returned an error")
+		err = oidc.NewRequestError(oidcServer, err)
 		return
 	}
 	defer func() { _ = res.Body.Close() }()
@@ -74,12 +129,51 @@ func (r PKCELogin) Handle(oidcServer string) (response *OIDCResponse, err error)
 	b, _ := ioutil.ReadAll(res.Body)
 	if err = json.Unmarshal(b, response); err != nil {
 		r.logger.Error("Cannot unmarshal OIDC configuration", zap.String("OIDCServer", oidcServer), zap.Error(err), zap.ByteString("body", b))
+		if res.StatusCode != http.StatusOK {
+			return nil, oidc.NewHTTPError(res.StatusCode, res.Status, res.Request.URL.String())
+		}
 		err = fmt.Errorf("the response body is not a valid JSON")
 		return
 	}
-	if len(response.Error) > 0 {
-		err = fmt.Errorf("server returned the error %s", response.Error)
+	switch {
+	case len(response.Error) > 0:
+		return nil, oidc.NewOAuthError(res.StatusCode, response.Error, response.ErrorDescription, response.ErrorURI)
+	case res.StatusCode != http.StatusOK:
+		return nil, oidc.NewHTTPError(res.StatusCode, res.Status, res.Request.URL.String())
+	}
+	if err = validateConfiguration(oidcServer, response); err != nil {
+		r.logger.Error("Invalid OIDC configuration", zap.String("OIDCServer", oidcServer), zap.Error(err), zap.ByteString("body", b))
+		return nil, err
+	}
+
+	oidc.WriteCache(r.cachePath, b, res.Header.Get("Cache-Control"), defaultDiscoveryMaxAge)
+
+	return response, nil
+}
+
+// validateConfiguration checks the discovery document provides what any login requires.
+func validateConfiguration(oidcServer string, response *OIDCResponse) error {
+	if response.Issuer != oidcServer {
+		return NewIssuerMismatchError(oidcServer, response.Issuer)
+	}
+	if len(response.TokenEndpoint) == 0 {
+		return fmt.Errorf("the OIDC server doesn't advertise the token_endpoint")
+	}
+	if len(response.JWKSURI) == 0 {
+		return fmt.Errorf("the OIDC server doesn't advertise the jwks_uri, the ID tokens cannot be validated")
 	}
+	return nil
+}
 
-	return
+// cached returns the discovery document stored on disk, unless expired or issued by another server.
+func (r PKCELogin) cached(oidcServer string) *OIDCResponse {
+	b, ok := oidc.ReadCache(r.cachePath)
+	if !ok {
+		return nil
+	}
+	response := &OIDCResponse{}
+	if err := json.Unmarshal(b, response); err != nil || validateConfiguration(oidcServer, response) != nil {
+		return nil
+	}
+	return response
 }
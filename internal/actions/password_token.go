@@ -0,0 +1,60 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package actions
+
+import (
+	"net/url"
+
+	"go.uber.org/zap"
+
+	"github.com/clastix/kubectl-login/internal/oidc"
+)
+
+type PasswordToken struct {
+	logger                            *zap.Logger
+	client                            *oidc.HTTPClient
+	auth                              *oidc.ClientAuthentication
+	tokenEndpoint, username, password string
+}
+
+func NewPasswordToken(logger *zap.Logger, tokenEndpoint, username, password string, auth *oidc.ClientAuthentication, httpClient *oidc.HTTPClient) *PasswordToken {
+	return &PasswordToken{
+		logger:        logger,
+		client:        httpClient,
+		auth:          auth,
+		tokenEndpoint: tokenEndpoint,
+		username:      username,
+		password:      password,
+	}
+}
+
+func (r PasswordToken) Handle() (idToken, refreshToken string, err error) {
+	r.logger.Info("Requesting tokens with the Resource Owner Password Credentials", zap.String("username", r.username))
+
+	d := url.Values{}
+	d.Add("grant_type", "password")
+	d.Add("username", r.username)
+	d.Add("password", r.password)
+	d.Add("scope", "openid profile groups offline_access")
+
+	var t *tokenResponse
+	if t, err = requestToken(r.logger, &r.client.Client, r.auth, r.tokenEndpoint, d, false); err != nil {
+		return
+	}
+
+	return t.IDToken, t.RefreshToken, nil
+}
@@ -17,38 +17,27 @@ limitations under the License.
 package actions
 
 import (
-	"crypto/tls"
-	"encoding/json"
-	"errors"
-	"fmt"
-	"io/ioutil"
-	"net/http"
 	"net/url"
-	"strings"
 
 	"go.uber.org/zap"
+
+	"github.com/clastix/kubectl-login/internal/oidc"
 )
 
 type RefreshToken struct {
-	logger                                      *zap.Logger
-	client                                      *http.Client
-	refreshEndpoint, oidcClientID, refreshToken string
+	logger                        *zap.Logger
+	client                        *oidc.HTTPClient
+	auth                          *oidc.ClientAuthentication
+	refreshEndpoint, refreshToken string
 }
 
-func NewRefreshToken(logger *zap.Logger, insecureSkipVerify bool, refreshEndpoint string, oidcClientID string, refreshToken string) *RefreshToken {
+func NewRefreshToken(logger *zap.Logger, refreshEndpoint, refreshToken string, auth *oidc.ClientAuthentication, httpClient *oidc.HTTPClient) *RefreshToken {
 	return &RefreshToken{
 		logger:          logger,
-		oidcClientID:    oidcClientID,
+		client:          httpClient,
+		auth:            auth,
 		refreshEndpoint: refreshEndpoint,
 		refreshToken:    refreshToken,
-		client: &http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{
-					//nolint:gosec
-					InsecureSkipVerify: insecureSkipVerify,
-				},
-			},
-		},
 	}
 }
 
@@ -56,41 +45,9 @@ func (r RefreshToken) Handle() (idToken, refresh string, err error) {
 	d := url.Values{}
 	d.Add("grant_type", "refresh_token")
 	d.Add("refresh_token", r.refreshToken)
-	d.Add("client_id", r.oidcClientID)
-
-	var tokenURL *url.URL
-	tokenURL, err = url.Parse(r.refreshEndpoint)
-	if err != nil {
-		r.logger.Error("Cannot retrieve OIDC token due to non well-formed endpoint", zap.Error(err), zap.String("refreshEndpoint", r.refreshEndpoint))
-		err = fmt.Errorf("non well-formed endpoint")
-		return
-	}
-
-	var res *http.Response
-	if res, err = r.client.Post(tokenURL.String(), "application/x-www-form-urlencoded", strings.NewReader(d.Encode())); err != nil {
-		r.logger.Error("The server returned an error", zap.Error(err), zap.String("uri", tokenURL.String()))
-		err = fmt.Errorf("the server returned an error")
-		return
-	}
-	defer func() { _ = res.Body.Close() }()
-
-	var b []byte
-	if b, err = ioutil.ReadAll(res.Body); err != nil {
-		r.logger.Error("Cannot read response body", zap.Error(err))
-		err = fmt.Errorf("cannot read response body")
-		return
-	}
-	t := &tokenResponse{}
-	if err = json.Unmarshal(b, t); err != nil {
-		r.logger.Error("Cannot unmarshal JSON response", zap.Error(err))
-		err = fmt.Errorf("the response body is not a valid JSON")
-		return
-	}
 
-	if len(t.Error) > 0 {
-		err = errors.New(t.Error)
-		r.logger.Error("Token retrieval failed", zap.Error(err))
-		err = fmt.Errorf("server returned the error %s", t.Error)
+	var t *tokenResponse
+	if t, err = requestToken(r.logger, &r.client.Client, r.auth, r.refreshEndpoint, d, true); err != nil {
 		return
 	}
 
@@ -0,0 +1,86 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package actions
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"go.uber.org/zap"
+
+	"github.com/clastix/kubectl-login/internal/oidc"
+)
+
+type RevokeToken struct {
+	logger             *zap.Logger
+	client             *oidc.HTTPClient
+	auth               *oidc.ClientAuthentication
+	revocationEndpoint string
+	token, hint        string
+}
+
+func NewRevokeToken(logger *zap.Logger, revocationEndpoint, token, tokenTypeHint string, auth *oidc.ClientAuthentication, httpClient *oidc.HTTPClient) *RevokeToken {
+	return &RevokeToken{
+		logger:             logger,
+		client:             httpClient,
+		auth:               auth,
+		revocationEndpoint: revocationEndpoint,
+		token:              token,
+		hint:               tokenTypeHint,
+	}
+}
+
+// Handle revokes the token as defined by RFC 7009.
+func (r RevokeToken) Handle() (err error) {
+	r.logger.Info("Revoking the token", zap.String("revocationEndpoint", r.revocationEndpoint), zap.String("hint", r.hint))
+
+	d := url.Values{}
+	d.Add("token", r.token)
+	if len(r.hint) > 0 {
+		d.Add("token_type_hint", r.hint)
+	}
+
+	var req *http.Request
+	if req, err = r.auth.NewRequest(r.revocationEndpoint, d); err != nil {
+		r.logger.Error("Cannot create the revocation request", zap.Error(err))
+		return fmt.Errorf("cannot authenticate the client (%w)", err)
+	}
+
+	var res *http.Response
+	if res, err = r.client.Do(req); err != nil {
+		r.logger.Error("The server returned an error", zap.Error(err), zap.String("uri", r.revocationEndpoint))
+		return oidc.NewRequestError(r.revocationEndpoint, err)
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	b, _ := ioutil.ReadAll(res.Body)
+	t := &tokenResponse{}
+	if json.Unmarshal(b, t) == nil && len(t.Error) > 0 {
+		r.logger.Error("Token revocation failed", zap.String("error", t.Error), zap.String("description", t.ErrorDescription))
+		return t.oauthError(res)
+	}
+	r.logger.Error("Token revocation failed", zap.String("status", res.Status), zap.ByteString("body", b))
+
+	return oidc.NewHTTPError(res.StatusCode, res.Status, r.revocationEndpoint)
+}
@@ -0,0 +1,139 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package actions
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+// SelfSubjectReview has been introduced as alpha with Kubernetes 1.26, the most recent versions are tried first
+var selfSubjectReviewVersions = []string{"v1", "v1beta1", "v1alpha1"}
+
+type selfSubjectReview struct {
+	metav1.TypeMeta `json:",inline"`
+	Status          struct {
+		UserInfo authenticationv1.UserInfo `json:"userInfo"`
+	} `json:"status"`
+}
+
+type SelfSubjectReview struct {
+	logger *zap.Logger
+	config *rest.Config
+}
+
+func NewSelfSubjectReview(logger *zap.Logger, config *rest.Config) *SelfSubjectReview {
+	return &SelfSubjectReview{
+		logger: logger,
+		config: config,
+	}
+}
+
+// Handle returns the user authenticated with the configured token, using a SelfSubjectReview or a TokenReview.
+func (r SelfSubjectReview) Handle() (user *authenticationv1.UserInfo, err error) {
+	var client *http.Client
+	if client, err = kubernetesClient(r.logger, r.config); err != nil {
+		return nil, err
+	}
+
+	for _, v := range selfSubjectReviewVersions {
+		review := &selfSubjectReview{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "SelfSubjectReview",
+				APIVersion: authenticationv1.GroupName + "/" + v,
+			},
+		}
+		var found bool
+		if found, err = r.post(client, fmt.Sprintf("/apis/%s/%s/selfsubjectreviews", authenticationv1.GroupName, v), review); err != nil {
+			return nil, err
+		}
+		if found {
+			return &review.Status.UserInfo, nil
+		}
+		r.logger.Debug("SelfSubjectReview not served", zap.String("version", v))
+	}
+
+	r.logger.Info("SelfSubjectReview not available, falling back to the TokenReview")
+	review := &authenticationv1.TokenReview{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "TokenReview",
+			APIVersion: authenticationv1.SchemeGroupVersion.String(),
+		},
+		Spec: authenticationv1.TokenReviewSpec{Token: r.config.BearerToken},
+	}
+	var found bool
+	if found, err = r.post(client, fmt.Sprintf("/apis/%s/tokenreviews", authenticationv1.SchemeGroupVersion.String()), review); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("the Kubernetes API server serves neither the SelfSubjectReview nor the TokenReview")
+	}
+	if !review.Status.Authenticated {
+		return nil, fmt.Errorf("the Kubernetes API server doesn't authenticate the token: %s", review.Status.Error)
+	}
+
+	return &review.Status.User, nil
+}
+
+// post creates the given review, decoding the response in it: false is returned when the API is not served.
+func (r SelfSubjectReview) post(client *http.Client, path string, review interface{}) (bool, error) {
+	b, err := json.Marshal(review)
+	if err != nil {
+		return false, fmt.Errorf("cannot encode the review (%w)", err)
+	}
+
+	var res *http.Response
+	if res, err = client.Post(strings.TrimRight(r.config.Host, "/")+path, "application/json", bytes.NewReader(b)); err != nil {
+		r.logger.Error("The Kubernetes API server returned an error", zap.Error(err), zap.String("path", path))
+		return false, fmt.Errorf("the Kubernetes API server returned an error")
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	if b, err = ioutil.ReadAll(res.Body); err != nil {
+		return false, fmt.Errorf("cannot read response body")
+	}
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+	case http.StatusNotFound:
+		return false, nil
+	case http.StatusUnauthorized:
+		return false, fmt.Errorf("the Kubernetes API server rejected the token")
+	default:
+		status := &metav1.Status{}
+		if json.Unmarshal(b, status) == nil && len(status.Message) > 0 {
+			return false, fmt.Errorf("the Kubernetes API server returned %s: %s", res.Status, status.Message)
+		}
+		return false, fmt.Errorf("the Kubernetes API server returned %s", res.Status)
+	}
+
+	if err = json.Unmarshal(b, review); err != nil {
+		r.logger.Error("Cannot unmarshal JSON response", zap.Error(err))
+		return false, fmt.Errorf("the response body is not a valid JSON")
+	}
+
+	return true, nil
+}
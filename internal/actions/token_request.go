@@ -0,0 +1,98 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package actions
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"go.uber.org/zap"
+
+	"github.com/clastix/kubectl-login/internal/oidc"
+)
+
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+	ErrorURI         string `json:"error_uri"`
+}
+
+// requestToken posts the given form to the token endpoint, returning the OAuth errors as *oidc.OAuthError: requests
+// safe to be repeated are retried upon server errors too.
+func requestToken(logger *zap.Logger, client *http.Client, auth *oidc.ClientAuthentication, tokenEndpoint string, d url.Values, repeatable bool) (t *tokenResponse, err error) {
+	var tokenURL *url.URL
+	tokenURL, err = url.Parse(tokenEndpoint)
+	if err != nil {
+		logger.Error("Cannot retrieve OIDC token due to non well-formed endpoint", zap.Error(err), zap.String("tokenEndpoint", tokenEndpoint))
+		return nil, fmt.Errorf("non well-formed endpoint")
+	}
+
+	var req *http.Request
+	if req, err = auth.NewRequest(tokenURL.String(), d); err != nil {
+		logger.Error("Cannot create the token request", zap.Error(err))
+		return nil, fmt.Errorf("cannot authenticate the client (%w)", err)
+	}
+	if repeatable {
+		req = oidc.RetryServerErrors(req)
+	}
+
+	var res *http.Response
+	if res, err = client.Do(req); err != nil {
+		logger.Error("The server returned an error", zap.Error(err), zap.String("uri", tokenURL.String()))
+		return nil, oidc.NewRequestError(tokenURL.String(), err)
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	var b []byte
+	if b, err = ioutil.ReadAll(res.Body); err != nil {
+		logger.Error("Cannot read response body", zap.Error(err))
+		return nil, fmt.Errorf("cannot read response body")
+	}
+	t = &tokenResponse{}
+	if err = json.Unmarshal(b, t); err != nil {
+		if res.StatusCode != http.StatusOK {
+			logger.Error("Token retrieval failed", zap.String("status", res.Status), zap.ByteString("body", b))
+			return nil, oidc.NewHTTPError(res.StatusCode, res.Status, tokenURL.String())
+		}
+		logger.Error("Cannot unmarshal JSON response", zap.Error(err))
+		return nil, fmt.Errorf("the response body is not a valid JSON")
+	}
+	if err = t.oauthError(res); err != nil {
+		logger.Debug("Token retrieval failed", zap.Error(err))
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		logger.Error("Token retrieval failed", zap.String("status", res.Status), zap.ByteString("body", b))
+		return nil, oidc.NewHTTPError(res.StatusCode, res.Status, tokenURL.String())
+	}
+
+	return t, nil
+}
+
+// oauthError returns the OAuth error carried by the response, if any.
+func (t tokenResponse) oauthError(res *http.Response) error {
+	if len(t.Error) == 0 {
+		return nil
+	}
+	return oidc.NewOAuthError(res.StatusCode, t.Error, t.ErrorDescription, t.ErrorURI)
+}
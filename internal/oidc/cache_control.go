@@ -0,0 +1,102 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oidc
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// cacheEntry is a response body stored on disk along with its expiration.
+type cacheEntry struct {
+	Expires time.Time       `json:"expires"`
+	Body    json.RawMessage `json:"body"`
+}
+
+// CacheMaxAge returns the max-age of the Cache-Control header, otherwise the given fallback.
+func CacheMaxAge(header string, fallback time.Duration) (time.Duration, bool) {
+	maxAge := fallback
+	for _, directive := range strings.Split(header, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-store", directive == "no-cache":
+			return 0, false
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err != nil {
+				return 0, false
+			}
+			maxAge = time.Duration(seconds) * time.Second
+		}
+	}
+	return maxAge, maxAge > 0
+}
+
+// ReadCache returns the response body cached at the given path, unless expired: an empty path disables the cache.
+func ReadCache(path string) ([]byte, bool) {
+	if len(path) == 0 {
+		return nil, false
+	}
+	b, err := afero.ReadFile(afero.NewOsFs(), path)
+	if err != nil {
+		return nil, false
+	}
+	c := &cacheEntry{}
+	if err = json.Unmarshal(b, c); err != nil || len(c.Body) == 0 || time.Now().After(c.Expires) {
+		return nil, false
+	}
+	return c.Body, true
+}
+
+// WriteCache stores the response body at the given path for the lifetime allowed by its Cache-Control header: the
+// file is replaced atomically, so that the concurrent executions never read it partially written.
+func WriteCache(path string, body []byte, cacheControl string, fallback time.Duration) {
+	maxAge, ok := CacheMaxAge(cacheControl, fallback)
+	if len(path) == 0 || !ok {
+		return
+	}
+	b, err := json.Marshal(&cacheEntry{Expires: time.Now().Add(maxAge), Body: body})
+	if err != nil {
+		return
+	}
+	fs := afero.NewOsFs()
+	if err = fs.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+	f, err := afero.TempFile(fs, filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(b)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = fs.Chmod(f.Name(), 0600)
+	}
+	if err == nil {
+		err = fs.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = fs.Remove(f.Name())
+	}
+}
@@ -0,0 +1,74 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oidc
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheMaxAge(t *testing.T) {
+	testCases := map[string]struct {
+		header   string
+		expected time.Duration
+		ok       bool
+	}{
+		"not set":  {header: "", expected: time.Hour, ok: true},
+		"max-age":  {header: "public, max-age=60", expected: time.Minute, ok: true},
+		"no-store": {header: "no-store", ok: false},
+		"no-cache": {header: "max-age=60, no-cache", ok: false},
+		"zero":     {header: "max-age=0", ok: false},
+		"invalid":  {header: "max-age=soon", ok: false},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			d, ok := CacheMaxAge(tc.header, time.Hour)
+			if ok != tc.ok || (ok && d != tc.expected) {
+				t.Errorf("expected %s (%t), got %s (%t)", tc.expected, tc.ok, d, ok)
+			}
+		})
+	}
+}
+
+func TestCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache", "document.json")
+
+	if _, ok := ReadCache(path); ok {
+		t.Fatalf("expected no cached document")
+	}
+	WriteCache(path, []byte(`{"issuer":"a"}`), "max-age=60", time.Hour)
+	if b, ok := ReadCache(path); !ok || string(b) != `{"issuer":"a"}` {
+		t.Errorf("expected the cached document, got %q", b)
+	}
+
+	WriteCache(path, []byte(`{"issuer":"b"}`), "no-store", time.Hour)
+	if b, _ := ReadCache(path); string(b) != `{"issuer":"a"}` {
+		t.Errorf("expected the no-store document not to be cached, got %q", b)
+	}
+
+	WriteCache(path, []byte(`{"issuer":"c"}`), "max-age=1", time.Hour)
+	time.Sleep(1100 * time.Millisecond)
+	if _, ok := ReadCache(path); ok {
+		t.Errorf("expected the expired document not to be returned")
+	}
+
+	WriteCache("", []byte(`{}`), "", time.Hour)
+	if _, ok := ReadCache(""); ok {
+		t.Errorf("expected the empty path to disable the cache")
+	}
+}
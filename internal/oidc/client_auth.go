@@ -0,0 +1,261 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/spf13/afero"
+)
+
+// Client authentication methods, as registered by OpenID Connect Core 1.0, section 9
+const (
+	ClientAuthNone              = "none"
+	ClientAuthClientSecretBasic = "client_secret_basic"
+	ClientAuthClientSecretPost  = "client_secret_post"
+	ClientAuthPrivateKeyJWT     = "private_key_jwt"
+)
+
+const (
+	clientAssertionType     = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	clientAssertionLifetime = 5 * time.Minute
+)
+
+type ClientAuthMethodError struct {
+	method    string
+	supported []string
+}
+
+func (r ClientAuthMethodError) Error() string {
+	return fmt.Sprintf("The client authentication method %s is not supported by the OIDC server (supported: %s)", r.method, strings.Join(r.supported, ", "))
+}
+
+func NewClientAuthMethodError(method string, supported []string) error {
+	return &ClientAuthMethodError{method: method, supported: supported}
+}
+
+type PrivateKeyError struct {
+	error error
+}
+
+func (r PrivateKeyError) Error() string {
+	return fmt.Sprintf("Cannot load the client private key: %s", r.error.Error())
+}
+
+func NewPrivateKeyError(error error) error {
+	return &PrivateKeyError{error: error}
+}
+
+// ResolveClientAuthMethod returns the requested method, or the one inferred from the provided credentials.
+func ResolveClientAuthMethod(requested, clientSecret, privateKeyPath string, supported []string) (method string, err error) {
+	isSupported := func(m string) bool {
+		// Not advertised by the server: there's nothing to check against
+		if len(supported) == 0 {
+			return true
+		}
+		for _, s := range supported {
+			if s == m {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case len(requested) > 0:
+		method = requested
+	case len(privateKeyPath) > 0:
+		method = ClientAuthPrivateKeyJWT
+	case len(clientSecret) > 0 && !isSupported(ClientAuthClientSecretBasic) && isSupported(ClientAuthClientSecretPost):
+		method = ClientAuthClientSecretPost
+	case len(clientSecret) > 0:
+		// According to OpenID Connect Discovery 1.0, section 3, client_secret_basic is the default
+		method = ClientAuthClientSecretBasic
+	default:
+		// Public clients are just identified by their ID
+		return ClientAuthNone, nil
+	}
+
+	if !isSupported(method) {
+		return "", NewClientAuthMethodError(method, supported)
+	}
+
+	return method, nil
+}
+
+// ClientAuthentication authenticates the client against the token endpoint using the configured method.
+type ClientAuthentication struct {
+	method, clientID, clientSecret, keyID string
+	key                                   crypto.Signer
+	// The token endpoint, audience of the private_key_jwt assertion whatever the endpoint called
+	tokenEndpoint string
+}
+
+func NewClientAuthentication(method, clientID, clientSecret, privateKeyPath, keyID, tokenEndpoint string) (auth *ClientAuthentication, err error) {
+	auth = &ClientAuthentication{
+		method:        method,
+		clientID:      clientID,
+		clientSecret:  clientSecret,
+		keyID:         keyID,
+		tokenEndpoint: tokenEndpoint,
+	}
+
+	switch method {
+	case "", ClientAuthNone:
+		auth.method = ClientAuthNone
+	case ClientAuthClientSecretBasic, ClientAuthClientSecretPost:
+		if len(clientSecret) == 0 {
+			return nil, fmt.Errorf("the client authentication method %s requires a client secret", method)
+		}
+	case ClientAuthPrivateKeyJWT:
+		if auth.key, err = loadPrivateKey(privateKeyPath); err != nil {
+			return nil, NewPrivateKeyError(err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown client authentication method %s", method)
+	}
+
+	return auth, nil
+}
+
+func (r ClientAuthentication) ClientID() string {
+	return r.clientID
+}
+
+func (r ClientAuthentication) Method() string {
+	return r.method
+}
+
+// NewRequest creates the form POST request to the given endpoint, carrying the client credentials.
+func (r ClientAuthentication) NewRequest(endpoint string, form url.Values) (req *http.Request, err error) {
+	d := url.Values{}
+	for k, v := range form {
+		d[k] = v
+	}
+	d.Set("client_id", r.clientID)
+
+	switch r.method {
+	case ClientAuthClientSecretPost:
+		d.Set("client_secret", r.clientSecret)
+	case ClientAuthPrivateKeyJWT:
+		var assertion string
+		if assertion, err = r.clientAssertion(r.tokenEndpoint); err != nil {
+			return nil, err
+		}
+		d.Set("client_assertion_type", clientAssertionType)
+		d.Set("client_assertion", assertion)
+	}
+
+	if req, err = http.NewRequest(http.MethodPost, endpoint, strings.NewReader(d.Encode())); err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if r.method == ClientAuthClientSecretBasic {
+		// RFC 6749, section 2.3.1: credentials are form-encoded before being used as Basic credentials
+		req.SetBasicAuth(url.QueryEscape(r.clientID), url.QueryEscape(r.clientSecret))
+	}
+
+	return req, nil
+}
+
+// clientAssertion signs the private_key_jwt assertion (RFC 7523) for the token endpoint.
+func (r ClientAuthentication) clientAssertion(audience string) (string, error) {
+	var method jwt.SigningMethod
+	switch k := r.key.(type) {
+	case *rsa.PrivateKey:
+		method = jwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P384():
+			method = jwt.SigningMethodES384
+		case elliptic.P521():
+			method = jwt.SigningMethodES512
+		default:
+			method = jwt.SigningMethodES256
+		}
+	default:
+		return "", fmt.Errorf("unsupported private key type %T", r.key)
+	}
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(method, jwt.StandardClaims{
+		Issuer:    r.clientID,
+		Subject:   r.clientID,
+		Audience:  audience,
+		Id:        base64.RawURLEncoding.EncodeToString(jti),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(clientAssertionLifetime).Unix(),
+	})
+	if len(r.keyID) > 0 {
+		token.Header["kid"] = r.keyID
+	}
+
+	return token.SignedString(r.key)
+}
+
+func loadPrivateKey(path string) (crypto.Signer, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("missing private key path")
+	}
+
+	b, err := afero.ReadFile(afero.NewOsFs(), path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+
+	if k, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		switch key := k.(type) {
+		case *rsa.PrivateKey:
+			return key, nil
+		case *ecdsa.PrivateKey:
+			return key, nil
+		default:
+			return nil, fmt.Errorf("unsupported private key type %T, only RSA and EC keys are allowed", k)
+		}
+	}
+	if k, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return k, nil
+	}
+	if k, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return k, nil
+	}
+
+	return nil, fmt.Errorf("the PEM block is not a PKCS#8, PKCS#1 or SEC 1 private key")
+}
@@ -0,0 +1,93 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oidc
+
+import (
+	"errors"
+	"fmt"
+)
+
+// OAuth error codes the callers act on, as defined by RFC 6749, section 5.2, and RFC 8628, section 3.5
+const (
+	ErrorInvalidGrant         = "invalid_grant"
+	ErrorAuthorizationPending = "authorization_pending"
+	ErrorSlowDown             = "slow_down"
+	ErrorAccessDenied         = "access_denied"
+	ErrorExpiredToken         = "expired_token"
+)
+
+// OAuthError is the error response of the OIDC server.
+type OAuthError struct {
+	StatusCode  int
+	Code        string
+	Description string
+	URI         string
+}
+
+func (r OAuthError) Error() string {
+	msg := fmt.Sprintf("The OIDC server returned the error %s", r.Code)
+	if len(r.Description) > 0 {
+		msg = fmt.Sprintf("%s: %s", msg, r.Description)
+	}
+	if len(r.URI) > 0 {
+		msg = fmt.Sprintf("%s (%s)", msg, r.URI)
+	}
+	return msg
+}
+
+func NewOAuthError(statusCode int, code, description, uri string) error {
+	return &OAuthError{StatusCode: statusCode, Code: code, Description: description, URI: uri}
+}
+
+// HTTPError is an unexpected status of the OIDC server, without an OAuth error response.
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	URI        string
+}
+
+func (r HTTPError) Error() string {
+	return fmt.Sprintf("The OIDC server returned %s for %s", r.Status, r.URI)
+}
+
+func NewHTTPError(statusCode int, status, uri string) error {
+	return &HTTPError{StatusCode: statusCode, Status: status, URI: uri}
+}
+
+// RequestError is a failure reaching the OIDC server, e.g. a network or TLS error.
+type RequestError struct {
+	URI   string
+	error error
+}
+
+func (r RequestError) Error() string {
+	return fmt.Sprintf("Cannot reach the OIDC server at %s: %s", r.URI, r.error.Error())
+}
+
+func (r RequestError) Unwrap() error {
+	return r.error
+}
+
+func NewRequestError(uri string, error error) error {
+	return &RequestError{URI: uri, error: error}
+}
+
+// IsOAuthError reports whether the given error is an OAuth error response with the given code.
+func IsOAuthError(err error, code string) bool {
+	var oauthErr *OAuthError
+	return errors.As(err, &oauthErr) && oauthErr.Code == code
+}
@@ -0,0 +1,194 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oidc
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Asymmetric algorithms only: the symmetric ones would require the client secret, "none" is never accepted
+var idTokenSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+type IDTokenValidationError struct {
+	reason string
+}
+
+func (r IDTokenValidationError) Error() string {
+	return fmt.Sprintf("The ID token is not valid: %s", r.reason)
+}
+
+func NewIDTokenValidationError(format string, args ...interface{}) error {
+	return &IDTokenValidationError{reason: fmt.Sprintf(format, args...)}
+}
+
+type IDTokenExpiredError struct {
+	expiration time.Time
+}
+
+func (r IDTokenExpiredError) Error() string {
+	return fmt.Sprintf("The ID token expired at %s", r.expiration.Format(time.RFC3339))
+}
+
+func NewIDTokenExpiredError(expiration time.Time) error {
+	return &IDTokenExpiredError{expiration: expiration}
+}
+
+type NonceMismatchError struct {
+	missing bool
+}
+
+func (r NonceMismatchError) Error() string {
+	if r.missing {
+		return "The ID token is missing the nonce claim, it cannot be bound to this login attempt (possible replay)"
+	}
+	return "The nonce claim of the ID token doesn't match this login attempt (possible replay)"
+}
+
+func NewNonceMismatchError(missing bool) error {
+	return &NonceMismatchError{missing: missing}
+}
+
+// IDTokenVerifier validates the ID token as required by OpenID Connect Core 1.0, section 3.1.3.7.
+type IDTokenVerifier struct {
+	keySet           *KeySet
+	issuer, clientID string
+	clockSkew        time.Duration
+}
+
+func NewIDTokenVerifier(keySet *KeySet, issuer, clientID string, clockSkew time.Duration) *IDTokenVerifier {
+	return &IDTokenVerifier{
+		keySet:    keySet,
+		issuer:    issuer,
+		clientID:  clientID,
+		clockSkew: clockSkew,
+	}
+}
+
+// Verify checks the signature and the claims of the given ID token, reporting an expired one as IDTokenExpiredError.
+func (r IDTokenVerifier) Verify(raw string) (claims jwt.MapClaims, err error) {
+	claims = jwt.MapClaims{}
+	parser := &jwt.Parser{
+		ValidMethods:         idTokenSigningMethods,
+		SkipClaimsValidation: true,
+	}
+	if _, err = parser.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return r.keySet.Key(kid, token.Method.Alg())
+	}); err != nil {
+		if ve, ok := err.(*jwt.ValidationError); ok && ve.Inner != nil {
+			err = ve.Inner
+		}
+		return nil, NewIDTokenValidationError("%s", err.Error())
+	}
+
+	if iss, _ := claims["iss"].(string); iss != r.issuer {
+		return nil, NewIDTokenValidationError("issuer %q doesn't match the expected %q", iss, r.issuer)
+	}
+
+	audiences := audience(claims)
+	found := false
+	for _, aud := range audiences {
+		if aud == r.clientID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, NewIDTokenValidationError("audience %v doesn't contain the client ID %q", audiences, r.clientID)
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != r.clientID {
+		return nil, NewIDTokenValidationError("authorized party %q doesn't match the client ID %q", azp, r.clientID)
+	}
+	if _, ok := claims["azp"]; !ok && len(audiences) > 1 {
+		return nil, NewIDTokenValidationError("the authorized party is required when multiple audiences are present")
+	}
+
+	now := time.Now()
+	exp, ok := numericDate(claims, "exp")
+	if !ok {
+		return nil, NewIDTokenValidationError("missing expiration time")
+	}
+	if now.After(exp.Add(r.clockSkew)) {
+		return claims, NewIDTokenExpiredError(exp)
+	}
+	iat, ok := numericDate(claims, "iat")
+	if !ok {
+		return nil, NewIDTokenValidationError("missing issue time")
+	}
+	if iat.After(now.Add(r.clockSkew)) {
+		return nil, NewIDTokenValidationError("issued in the future (%s)", iat.Format(time.RFC3339))
+	}
+	if nbf, ok := numericDate(claims, "nbf"); ok && nbf.After(now.Add(r.clockSkew)) {
+		return nil, NewIDTokenValidationError("not valid before %s", nbf.Format(time.RFC3339))
+	}
+
+	return claims, nil
+}
+
+// VerifyNonce checks the nonce claim of the validated ID token matches the one sent with the authentication request.
+func VerifyNonce(claims jwt.MapClaims, nonce string) error {
+	n, ok := claims["nonce"].(string)
+	if !ok || len(n) == 0 {
+		return NewNonceMismatchError(true)
+	}
+	if subtle.ConstantTimeCompare([]byte(n), []byte(nonce)) != 1 {
+		return NewNonceMismatchError(false)
+	}
+	return nil
+}
+
+// ExpirationTime returns the exp claim of the given ID token claims.
+func ExpirationTime(claims jwt.MapClaims) (time.Time, bool) {
+	return numericDate(claims, "exp")
+}
+
+// Audience returns the aud claim of the given ID token claims.
+func Audience(claims jwt.MapClaims) []string {
+	return audience(claims)
+}
+
+// audience returns the aud claim, that can be either a single string or an array of strings.
+func audience(claims jwt.MapClaims) (out []string) {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				out = append(out, s)
+			}
+		}
+	}
+	return
+}
+
+func numericDate(claims jwt.MapClaims, name string) (time.Time, bool) {
+	switch v := claims[name].(type) {
+	case float64:
+		return time.Unix(int64(v), 0), true
+	case json.Number:
+		i, err := v.Int64()
+		return time.Unix(i, 0), err == nil
+	default:
+		return time.Time{}, false
+	}
+}
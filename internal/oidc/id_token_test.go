@@ -0,0 +1,309 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	testIssuer   = "https://idp.example.com"
+	testClientID = "kubectl"
+)
+
+// testKeySetServer serves the JWKS of the given keys, replaceable to simulate a rotation.
+type testKeySetServer struct {
+	*httptest.Server
+	mu   sync.Mutex
+	keys []jsonWebKey
+	hits int32
+}
+
+func newTestKeySetServer(t *testing.T, keys ...jsonWebKey) *testKeySetServer {
+	s := &testKeySetServer{keys: keys}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&s.hits, 1)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(&jsonWebKeySet{Keys: s.keys})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testKeySetServer) rotate(keys ...jsonWebKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+func rsaJWK(kid, alg string, key *rsa.PrivateKey) jsonWebKey {
+	return jsonWebKey{
+		KeyType:   "RSA",
+		KeyID:     kid,
+		Algorithm: alg,
+		Modulus:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		Exponent:  base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PrivateKey) jsonWebKey {
+	return jsonWebKey{
+		KeyType: "EC",
+		KeyID:   kid,
+		Curve:   "P-256",
+		X:       base64.RawURLEncoding.EncodeToString(key.X.Bytes()),
+		Y:       base64.RawURLEncoding.EncodeToString(key.Y.Bytes()),
+	}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if len(kid) > 0 {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("cannot sign the token: %v", err)
+	}
+	return s
+}
+
+func validClaims(changes jwt.MapClaims) jwt.MapClaims {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss": testIssuer,
+		"aud": testClientID,
+		"sub": "alice",
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+	for k, v := range changes {
+		if v == nil {
+			delete(claims, k)
+			continue
+		}
+		claims[k] = v
+	}
+	return claims
+}
+
+func TestIDTokenVerifier(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestKeySetServer(t, rsaJWK("rsa", "RS256", rsaKey), ecJWK("ec", ecKey), rsaJWK("rs384", "RS384", otherKey))
+
+	now := time.Now()
+	testCases := map[string]struct {
+		token   func(t *testing.T) string
+		expired bool
+		wantErr bool
+	}{
+		"valid RSA signature": {
+			token: func(t *testing.T) string { return sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, validClaims(nil)) },
+		},
+		"valid EC signature": {
+			token: func(t *testing.T) string { return sign(t, jwt.SigningMethodES256, "ec", ecKey, validClaims(nil)) },
+		},
+		"tampered signature": {
+			token: func(t *testing.T) string {
+				parts := strings.Split(sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, validClaims(nil)), ".")
+				forged, _ := json.Marshal(validClaims(jwt.MapClaims{"sub": "mallory"}))
+				parts[1] = base64.RawURLEncoding.EncodeToString(forged)
+				return strings.Join(parts, ".")
+			},
+			wantErr: true,
+		},
+		"signed by another key": {
+			token:   func(t *testing.T) string { return sign(t, jwt.SigningMethodRS256, "rsa", otherKey, validClaims(nil)) },
+			wantErr: true,
+		},
+		"unknown key ID": {
+			token:   func(t *testing.T) string { return sign(t, jwt.SigningMethodRS256, "missing", rsaKey, validClaims(nil)) },
+			wantErr: true,
+		},
+		"algorithm not matching the key": {
+			token:   func(t *testing.T) string { return sign(t, jwt.SigningMethodRS256, "rs384", otherKey, validClaims(nil)) },
+			wantErr: true,
+		},
+		"none algorithm": {
+			token: func(t *testing.T) string {
+				return sign(t, jwt.SigningMethodNone, "rsa", jwt.UnsafeAllowNoneSignatureType, validClaims(nil))
+			},
+			wantErr: true,
+		},
+		"HS256 algorithm": {
+			token: func(t *testing.T) string {
+				return sign(t, jwt.SigningMethodHS256, "rsa", []byte("client-secret"), validClaims(nil))
+			},
+			wantErr: true,
+		},
+		"wrong issuer": {
+			token: func(t *testing.T) string {
+				return sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, validClaims(jwt.MapClaims{"iss": "https://evil.example.com"}))
+			},
+			wantErr: true,
+		},
+		"wrong audience": {
+			token: func(t *testing.T) string {
+				return sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, validClaims(jwt.MapClaims{"aud": "other"}))
+			},
+			wantErr: true,
+		},
+		"multiple audiences without authorized party": {
+			token: func(t *testing.T) string {
+				return sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, validClaims(jwt.MapClaims{"aud": []string{testClientID, "other"}}))
+			},
+			wantErr: true,
+		},
+		"multiple audiences with the client as authorized party": {
+			token: func(t *testing.T) string {
+				return sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, validClaims(jwt.MapClaims{"aud": []string{testClientID, "other"}, "azp": testClientID}))
+			},
+		},
+		"another authorized party": {
+			token: func(t *testing.T) string {
+				return sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, validClaims(jwt.MapClaims{"aud": []string{testClientID, "other"}, "azp": "other"}))
+			},
+			wantErr: true,
+		},
+		"expired within the clock skew": {
+			token: func(t *testing.T) string {
+				return sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, validClaims(jwt.MapClaims{"iat": now.Add(-time.Hour).Unix(), "exp": now.Add(-30 * time.Second).Unix()}))
+			},
+		},
+		"expired beyond the clock skew": {
+			token: func(t *testing.T) string {
+				return sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, validClaims(jwt.MapClaims{"iat": now.Add(-time.Hour).Unix(), "exp": now.Add(-2 * time.Minute).Unix()}))
+			},
+			expired: true,
+			wantErr: true,
+		},
+		"missing expiration": {
+			token: func(t *testing.T) string {
+				return sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, validClaims(jwt.MapClaims{"exp": nil}))
+			},
+			wantErr: true,
+		},
+		"issued in the future": {
+			token: func(t *testing.T) string {
+				return sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, validClaims(jwt.MapClaims{"iat": now.Add(5 * time.Minute).Unix()}))
+			},
+			wantErr: true,
+		},
+		"not valid before within the clock skew": {
+			token: func(t *testing.T) string {
+				return sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, validClaims(jwt.MapClaims{"nbf": now.Add(30 * time.Second).Unix()}))
+			},
+		},
+		"not valid before beyond the clock skew": {
+			token: func(t *testing.T) string {
+				return sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, validClaims(jwt.MapClaims{"nbf": now.Add(5 * time.Minute).Unix()}))
+			},
+			wantErr: true,
+		},
+	}
+
+	client, err := NewHTTPClient(HTTPClientOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			verifier := NewIDTokenVerifier(NewKeySet(client, server.URL, ""), testIssuer, testClientID, time.Minute)
+			claims, err := verifier.Verify(tc.token(t))
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var expired *IDTokenExpiredError
+			if errors.As(err, &expired) != tc.expired {
+				t.Fatalf("expected the expiration %t, got %v", tc.expired, err)
+			}
+			if tc.expired && claims == nil {
+				t.Errorf("expected the claims of the expired token")
+			}
+		})
+	}
+}
+
+func TestKeySetRotation(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestKeySetServer(t, rsaJWK("old", "", oldKey))
+	client, err := NewHTTPClient(HTTPClientOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cachePath := filepath.Join(t.TempDir(), "jwks.json")
+
+	verifier := NewIDTokenVerifier(NewKeySet(client, server.URL, cachePath), testIssuer, testClientID, 0)
+	if _, err = verifier.Verify(sign(t, jwt.SigningMethodRS256, "old", oldKey, validClaims(nil))); err != nil {
+		t.Fatalf("cannot verify the token signed with the old key: %v", err)
+	}
+
+	server.rotate(rsaJWK("old", "", oldKey), rsaJWK("new", "", newKey))
+	// The cached key set doesn't know the new key ID: it's fetched again, once per execution
+	verifier = NewIDTokenVerifier(NewKeySet(client, server.URL, cachePath), testIssuer, testClientID, 0)
+	if _, err = verifier.Verify(sign(t, jwt.SigningMethodRS256, "old", oldKey, validClaims(nil))); err != nil {
+		t.Fatalf("cannot verify the token signed with the old key from the cache: %v", err)
+	}
+	if hits := atomic.LoadInt32(&server.hits); hits != 1 {
+		t.Fatalf("expected the key set to be read from the cache, fetched %d times", hits)
+	}
+	if _, err = verifier.Verify(sign(t, jwt.SigningMethodRS256, "new", newKey, validClaims(nil))); err != nil {
+		t.Fatalf("cannot verify the token signed with the new key: %v", err)
+	}
+	if _, err = verifier.Verify(sign(t, jwt.SigningMethodRS256, "unknown", newKey, validClaims(nil))); err == nil {
+		t.Fatalf("expected the unknown key ID to be rejected")
+	}
+	if hits := atomic.LoadInt32(&server.hits); hits != 2 {
+		t.Errorf("expected the key set to be fetched again once, fetched %d times", hits)
+	}
+}
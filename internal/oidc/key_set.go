@@ -0,0 +1,230 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+// Lifetime of the cached key set when the OIDC server doesn't set the Cache-Control header
+const defaultKeySetMaxAge = time.Hour
+
+type jsonWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Modulus   string `json:"n"`
+	Exponent  string `json:"e"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+	Algorithm string `json:"alg"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+type UnknownKeyError struct {
+	kid string
+}
+
+func (r UnknownKeyError) Error() string {
+	return fmt.Sprintf("No key with ID %q in the OIDC server key set", r.kid)
+}
+
+func NewUnknownKeyError(kid string) error {
+	return &UnknownKeyError{kid: kid}
+}
+
+type KeyAlgorithmError struct {
+	kid, keyAlg, tokenAlg string
+}
+
+func (r KeyAlgorithmError) Error() string {
+	return fmt.Sprintf("The key with ID %q is restricted to the %s algorithm, the token is signed with %s", r.kid, r.keyAlg, r.tokenAlg)
+}
+
+func NewKeyAlgorithmError(kid, keyAlg, tokenAlg string) error {
+	return &KeyAlgorithmError{kid: kid, keyAlg: keyAlg, tokenAlg: tokenAlg}
+}
+
+// signingKey is a public key of the set, along with the algorithm it's restricted to, if any.
+type signingKey struct {
+	key crypto.PublicKey
+	alg string
+}
+
+// KeySet holds the signing keys published at the jwks_uri, fetched again upon unknown key IDs.
+type KeySet struct {
+	client         *HTTPClient
+	uri, cachePath string
+	keys           map[string]signingKey
+	fetched        bool
+}
+
+func NewKeySet(client *HTTPClient, uri, cachePath string) *KeySet {
+	return &KeySet{
+		client:    client,
+		uri:       uri,
+		cachePath: cachePath,
+	}
+}
+
+// Key returns the public key with the given ID verifying the given algorithm, an empty ID is accepted only if the set
+// contains a single key.
+func (r *KeySet) Key(kid, alg string) (crypto.PublicKey, error) {
+	if r.keys == nil {
+		if b, ok := ReadCache(r.cachePath); ok {
+			r.keys, _ = parseKeySet(b)
+		}
+	}
+
+	key, ok := r.lookup(kid)
+	if !ok && !r.fetched {
+		if err := r.fetch(); err != nil {
+			return nil, err
+		}
+		key, ok = r.lookup(kid)
+	}
+	if !ok {
+		return nil, NewUnknownKeyError(kid)
+	}
+	if len(key.alg) > 0 && key.alg != alg {
+		return nil, NewKeyAlgorithmError(kid, key.alg, alg)
+	}
+
+	return key.key, nil
+}
+
+func (r *KeySet) lookup(kid string) (signingKey, bool) {
+	if len(kid) == 0 && len(r.keys) == 1 {
+		for _, key := range r.keys {
+			return key, true
+		}
+	}
+	key, ok := r.keys[kid]
+	return key, ok
+}
+
+func (r *KeySet) fetch() (err error) {
+	r.fetched = true
+
+	var req *http.Request
+	if req, err = http.NewRequest(http.MethodGet, r.uri, nil); err != nil {
+		return fmt.Errorf("cannot retrieve the OIDC server key set (%w)", err)
+	}
+	var res *http.Response
+	if res, err = r.client.Do(RetryServerErrors(req)); err != nil {
+		return fmt.Errorf("cannot retrieve the OIDC server key set (%w)", err)
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("cannot retrieve the OIDC server key set, the server returned %s", res.Status)
+	}
+
+	var b []byte
+	if b, err = ioutil.ReadAll(res.Body); err != nil {
+		return fmt.Errorf("cannot read the OIDC server key set (%w)", err)
+	}
+	if r.keys, err = parseKeySet(b); err != nil {
+		return err
+	}
+
+	WriteCache(r.cachePath, b, res.Header.Get("Cache-Control"), defaultKeySetMaxAge)
+
+	return nil
+}
+
+func parseKeySet(b []byte) (map[string]signingKey, error) {
+	set := &jsonWebKeySet{}
+	if err := json.Unmarshal(b, set); err != nil {
+		return nil, fmt.Errorf("the OIDC server key set is not a valid JSON (%w)", err)
+	}
+
+	keys := make(map[string]signingKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if len(k.Use) > 0 && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			// Keys of unsupported types are skipped rather than invalidating the whole set
+			continue
+		}
+		keys[k.KeyID] = signingKey{key: key, alg: k.Algorithm}
+	}
+
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+
+	switch k.KeyType {
+	case "RSA":
+		n, err := decode(k.Modulus)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.Exponent)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Curve)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.KeyType)
+	}
+}
@@ -21,9 +21,11 @@ import (
 	"crypto/x509"
 	"fmt"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/spf13/afero"
+	"golang.org/x/net/http/httpproxy"
 )
 
 type CAReadFileError struct {
@@ -49,36 +51,146 @@ func NewOIDCCAPoolError() error {
 	return &CAPoolError{}
 }
 
+type ClientCertificateError struct {
+	error error
+}
+
+func (r ClientCertificateError) Error() string {
+	return fmt.Sprintf("Cannot load the OIDC client certificate: %s", r.error.Error())
+}
+
+func NewClientCertificateError(error error) error {
+	return &ClientCertificateError{error: error}
+}
+
+type ProxyURLError struct {
+	url string
+}
+
+func (r ProxyURLError) Error() string {
+	return fmt.Sprintf("The proxy URL %q is not valid, the http, https and socks5 schemes are supported", r.url)
+}
+
+func NewProxyURLError(url string) error {
+	return &ProxyURLError{url: url}
+}
+
+// HTTPClientOptions configures the connections to the OIDC server.
+type HTTPClientOptions struct {
+	// CertificateAuthorityPath is the PEM file of the OIDC server CA, trusted along with the system ones
+	CertificateAuthorityPath string
+	Timeout                  time.Duration
+	InsecureSkipVerify       bool
+	// ClientCertificatePath and ClientKeyPath are the PEM files of the certificate authenticating the TLS connections
+	ClientCertificatePath, ClientKeyPath string
+	// ProxyURL overrides the proxy of the environment variables, NoProxy the hosts excluded from it
+	ProxyURL, NoProxy string
+	// Headers are added to all the requests
+	Headers map[string]string
+	// MaxRetries is the number of retries of the requests failing with transient errors
+	MaxRetries int
+}
+
+// HTTPClient is shared by all the requests to the OIDC server: discovery, keys, token and revocation endpoints.
 type HTTPClient struct {
 	http.Client
 }
 
-func NewHTTPClient(certificateAuthorityPath string, timeout time.Duration, insecureSkipVerify bool) (client *HTTPClient, err error) {
+func NewHTTPClient(options HTTPClientOptions) (client *HTTPClient, err error) {
+	tlsConfig := &tls.Config{
+		//nolint:gosec
+		InsecureSkipVerify: options.InsecureSkipVerify,
+	}
+	if len(options.CertificateAuthorityPath) > 0 {
+		if tlsConfig.RootCAs, err = certPool(options.CertificateAuthorityPath); err != nil {
+			return nil, err
+		}
+	}
+	if len(options.ClientCertificatePath) > 0 || len(options.ClientKeyPath) > 0 {
+		var cert tls.Certificate
+		if cert, err = tls.LoadX509KeyPair(options.ClientCertificatePath, options.ClientKeyPath); err != nil {
+			return nil, NewClientCertificateError(err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	// Starting from the defaults, honoring the proxy environment variables and the connection timeouts
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	if transport.Proxy, err = proxy(options.ProxyURL, options.NoProxy); err != nil {
+		return nil, err
+	}
+
+	var roundTripper http.RoundTripper = transport
+	if len(options.Headers) > 0 {
+		roundTripper = &headerTransport{base: roundTripper, headers: options.Headers}
+	}
+	if options.MaxRetries > 0 {
+		roundTripper = &retryTransport{base: roundTripper, maxRetries: options.MaxRetries, timeout: options.Timeout}
+	}
+
 	return &HTTPClient{
 		Client: http.Client{
-			Timeout: timeout,
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{
-					//nolint:gosec
-					InsecureSkipVerify: insecureSkipVerify,
-					RootCAs: func() (pool *x509.CertPool) {
-						if len(certificateAuthorityPath) == 0 {
-							return nil
-						}
-						b, e := afero.ReadFile(afero.NewOsFs(), certificateAuthorityPath)
-						if e != nil {
-							err = NewCAReadFileError(e)
-							return nil
-						}
-						pool = x509.NewCertPool()
-						if !pool.AppendCertsFromPEM(b) {
-							err = NewOIDCCAPoolError()
-							return nil
-						}
-						return
-					}(),
-				},
-			},
+			Timeout:   options.Timeout,
+			Transport: roundTripper,
 		},
-	}, err
+	}, nil
+}
+
+// proxy returns the proxy selection of the environment variables, overridden by the given URL and exclusions.
+func proxy(proxyURL, noProxy string) (func(*http.Request) (*url.URL, error), error) {
+	cfg := httpproxy.FromEnvironment()
+	if len(proxyURL) > 0 {
+		u, err := url.Parse(proxyURL)
+		if err != nil || len(u.Host) == 0 {
+			return nil, NewProxyURLError(proxyURL)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, NewProxyURLError(proxyURL)
+		}
+		cfg.HTTPProxy, cfg.HTTPSProxy = proxyURL, proxyURL
+	}
+	if len(noProxy) > 0 {
+		cfg.NoProxy = noProxy
+	}
+
+	f := cfg.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return f(req.URL)
+	}, nil
+}
+
+// headerTransport adds the configured headers to the requests, e.g. the ones required by an authenticating proxy.
+type headerTransport struct {
+	base    http.RoundTripper
+	headers map[string]string
+}
+
+func (r headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the given request
+	req = req.Clone(req.Context())
+	for k, v := range r.headers {
+		req.Header.Set(k, v)
+	}
+	return r.base.RoundTrip(req)
+}
+
+// certPool returns the system pool along with the given CA, the system one being unavailable on some platforms.
+func certPool(certificateAuthorityPath string) (*x509.CertPool, error) {
+	b, err := afero.ReadFile(afero.NewOsFs(), certificateAuthorityPath)
+	if err != nil {
+		return nil, NewCAReadFileError(err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, NewOIDCCAPoolError()
+	}
+
+	return pool, nil
 }
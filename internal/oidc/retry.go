@@ -0,0 +1,150 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oidc
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 10 * time.Second
+	// A longer Retry-After is not waited for, the error is returned instead
+	retryAfterMax = time.Minute
+)
+
+type retryServerErrorsKey struct{}
+
+// RetryServerErrors marks the request as safe to be repeated, e.g. the refresh and the device code polling: it's
+// retried upon any transient failure, the other requests only when not sent or throttled.
+func RetryServerErrors(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), retryServerErrorsKey{}, true))
+}
+
+// retryTransport retries the requests failing with transient errors, within the client timeout.
+type retryTransport struct {
+	base       http.RoundTripper
+	maxRetries int
+	timeout    time.Duration
+}
+
+func (r retryTransport) RoundTrip(req *http.Request) (res *http.Response, err error) {
+	deadline, ok := req.Context().Deadline()
+	if !ok && r.timeout > 0 {
+		deadline = time.Now().Add(r.timeout)
+	}
+
+	attempt := req
+	for i := 0; ; i++ {
+		res, err = r.base.RoundTrip(attempt)
+
+		if i >= r.maxRetries || req.Context().Err() != nil {
+			return res, err
+		}
+		delay, retry := retryDelay(req, res, err, i)
+		// The body consumed by this attempt cannot be sent again
+		if !retry || (req.Body != nil && req.GetBody == nil) {
+			return res, err
+		}
+		// Rather than a timeout error, the failure is returned when the client would give up while waiting
+		if !deadline.IsZero() && time.Now().Add(delay).After(deadline) {
+			return res, err
+		}
+		if res != nil {
+			_ = res.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+
+		// A RoundTripper must not modify the given request
+		attempt = req.Clone(req.Context())
+		if req.Body != nil {
+			if attempt.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// retryDelay returns whether the attempt can be retried and after how long, honoring the Retry-After header.
+func retryDelay(req *http.Request, res *http.Response, err error, attempt int) (time.Duration, bool) {
+	serverErrors, _ := req.Context().Value(retryServerErrorsKey{}).(bool)
+	if err != nil && !serverErrors && !notSent(err) {
+		return 0, false
+	}
+	if err == nil {
+		switch res.StatusCode {
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+			// The request may have been processed, e.g. the authorization code redeemed
+			if !serverErrors {
+				return 0, false
+			}
+		default:
+			return 0, false
+		}
+		if d, ok := retryAfter(res.Header.Get("Retry-After")); ok {
+			return d, d <= retryAfterMax
+		}
+	}
+
+	d := retryBaseDelay << uint(attempt)
+	if d > retryMaxDelay {
+		d = retryMaxDelay
+	}
+	//nolint:gosec
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1)), true
+}
+
+// notSent returns whether the request failed before reaching the server.
+func notSent(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// retryAfter parses the Retry-After header, either in seconds or as an HTTP date.
+func retryAfter(v string) (time.Duration, bool) {
+	if len(v) == 0 {
+		return 0, false
+	}
+	if s, err := strconv.Atoi(v); err == nil && s >= 0 {
+		return time.Duration(s) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
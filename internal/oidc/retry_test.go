@@ -0,0 +1,220 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oidc
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "http://idp.example.com/token", nil)
+	for attempt, max := range []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, retryMaxDelay, retryMaxDelay} {
+		delays := make(map[time.Duration]bool)
+		for i := 0; i < 20; i++ {
+			d, ok := retryDelay(req, nil, errNotSent, attempt)
+			if !ok {
+				t.Fatalf("expected the attempt %d to be retried", attempt)
+			}
+			if d < max/2 || d > max {
+				t.Fatalf("expected the delay of the attempt %d within [%s, %s], got %s", attempt, max/2, max, d)
+			}
+			delays[d] = true
+		}
+		if len(delays) == 1 {
+			t.Errorf("expected the delays of the attempt %d to be spread by the jitter", attempt)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	testCases := map[string]struct {
+		header   string
+		min, max time.Duration
+		ok       bool
+	}{
+		"missing":         {header: ""},
+		"seconds":         {header: "3", min: 3 * time.Second, max: 3 * time.Second, ok: true},
+		"zero seconds":    {header: "0", ok: true},
+		"negative":        {header: "-1"},
+		"future date":     {header: time.Now().Add(30 * time.Second).UTC().Format(http.TimeFormat), min: 28 * time.Second, max: 30 * time.Second, ok: true},
+		"past date":       {header: time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), ok: true},
+		"malformed value": {header: "soon"},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			d, ok := retryAfter(tc.header)
+			if ok != tc.ok {
+				t.Fatalf("expected the header to be parsed %t, got %t", tc.ok, ok)
+			}
+			if d < tc.min || d > tc.max {
+				t.Errorf("expected a delay within [%s, %s], got %s", tc.min, tc.max, d)
+			}
+		})
+	}
+}
+
+func TestRetryAfterCap(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://idp.example.com/jwks", nil)
+	res := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{"Retry-After": {"120"}}}
+	if _, ok := retryDelay(req, res, nil, 0); ok {
+		t.Errorf("expected a Retry-After longer than %s not to be waited for", retryAfterMax)
+	}
+	res.Header.Set("Retry-After", "60")
+	if d, ok := retryDelay(req, res, nil, 0); !ok || d != time.Minute {
+		t.Errorf("expected to wait for the Retry-After, got %s", d)
+	}
+}
+
+func TestRetryTransport(t *testing.T) {
+	testCases := map[string]struct {
+		statuses   []int
+		repeatable bool
+		hits       int32
+		status     int
+	}{
+		"success": {
+			statuses: []int{http.StatusOK},
+			hits:     1,
+			status:   http.StatusOK,
+		},
+		"bad gateway": {
+			statuses: []int{http.StatusBadGateway, http.StatusOK},
+			hits:     1,
+			status:   http.StatusBadGateway,
+		},
+		"bad gateway of a repeatable request": {
+			statuses:   []int{http.StatusBadGateway, http.StatusGatewayTimeout, http.StatusOK},
+			repeatable: true,
+			hits:       3,
+			status:     http.StatusOK,
+		},
+		"service unavailable": {
+			statuses: []int{http.StatusServiceUnavailable, http.StatusOK},
+			hits:     2,
+			status:   http.StatusOK,
+		},
+		"too many requests": {
+			statuses: []int{http.StatusTooManyRequests, http.StatusOK},
+			hits:     2,
+			status:   http.StatusOK,
+		},
+		"retries exhausted": {
+			statuses:   []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusOK},
+			repeatable: true,
+			hits:       3,
+			status:     http.StatusBadGateway,
+		},
+		"OAuth error": {
+			statuses:   []int{http.StatusBadRequest, http.StatusOK},
+			repeatable: true,
+			hits:       1,
+			status:     http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var hits int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				i := atomic.AddInt32(&hits, 1) - 1
+				if b, _ := ioutil.ReadAll(r.Body); string(b) != "grant_type=refresh_token" {
+					t.Errorf("unexpected body of the attempt %d: %q", i, b)
+				}
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(tc.statuses[i])
+			}))
+			defer server.Close()
+
+			client := &http.Client{Transport: &retryTransport{base: http.DefaultTransport, maxRetries: 2}}
+			req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(url.Values{"grant_type": {"refresh_token"}}.Encode()))
+			body := req.Body
+			if tc.repeatable {
+				req = RetryServerErrors(req)
+			}
+			res, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			_ = res.Body.Close()
+			if res.StatusCode != tc.status {
+				t.Errorf("expected the status %d, got %d", tc.status, res.StatusCode)
+			}
+			if hits != tc.hits {
+				t.Errorf("expected %d attempts, got %d", tc.hits, hits)
+			}
+			if req.Body != body {
+				t.Errorf("expected the request body to be left untouched")
+			}
+		})
+	}
+}
+
+func TestRetryTransportConnectionRefused(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	base := &countingTransport{base: http.DefaultTransport}
+	client := &http.Client{Transport: &retryTransport{base: base, maxRetries: 1}}
+	if _, err := client.PostForm(server.URL, url.Values{"grant_type": {"authorization_code"}}); err == nil {
+		t.Fatal("expected the connection to be refused")
+	}
+	if base.attempts != 2 {
+		t.Errorf("expected the request not sent to be retried, got %d attempts", base.attempts)
+	}
+}
+
+func TestRetryTransportTimeout(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Retry-After", "5")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	timeout := 500 * time.Millisecond
+	client := &http.Client{Timeout: timeout, Transport: &retryTransport{base: http.DefaultTransport, maxRetries: 2, timeout: timeout}}
+	res, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("expected the server response instead of the timeout, got %v", err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable || hits != 1 {
+		t.Errorf("expected a single 503 response, got %d after %d attempts", res.StatusCode, hits)
+	}
+}
+
+var errNotSent = &url.Error{Op: "Post", URL: "http://idp.example.com/token", Err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}}
+
+type countingTransport struct {
+	base     http.RoundTripper
+	attempts int
+}
+
+func (r *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.attempts++
+	return r.base.RoundTrip(req)
+}
@@ -0,0 +1,62 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package qrcode
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"rsc.io/qr"
+)
+
+// quietZone is the number of light modules surrounding the symbol, required by scanners to locate it
+const quietZone = 2
+
+// Fprint renders the QR code of the given text using Unicode half blocks, each character encoding two modules.
+func Fprint(w io.Writer, text string) error {
+	code, err := qr.Encode(text, qr.L)
+	if err != nil {
+		return fmt.Errorf("cannot encode QR code (%w)", err)
+	}
+
+	// Modules are printed as light on dark terminals: a module is "on" when it's not black.
+	light := func(x, y int) bool {
+		return !code.Black(x, y)
+	}
+
+	sb := strings.Builder{}
+	for y := -quietZone; y < code.Size+quietZone; y += 2 {
+		for x := -quietZone; x < code.Size+quietZone; x++ {
+			top, bottom := light(x, y), light(x, y+1)
+			switch {
+			case top && bottom:
+				sb.WriteString("█")
+			case top:
+				sb.WriteString("▀")
+			case bottom:
+				sb.WriteString("▄")
+			default:
+				sb.WriteString(" ")
+			}
+		}
+		sb.WriteString("\n")
+	}
+
+	_, err = io.WriteString(w, sb.String())
+	return err
+}
@@ -0,0 +1,108 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	BackendEncryptedFile = "encrypted-file"
+	encryptedFileVersion = 1
+	saltSize             = 16
+	keySize              = 32
+	// scrypt parameters recommended for interactive logins
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// encryptedFile is the envelope of the tokens encrypted with AES-256-GCM, with a scrypt derived key.
+type encryptedFile struct {
+	Version    int    `json:"version"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// NewEncryptedFileStore keeps the tokens in a file encrypted with a key derived from the given secret.
+func NewEncryptedFileStore(path string, secret []byte) (TokenStore, error) {
+	if len(secret) == 0 {
+		return nil, NewStoreError(BackendEncryptedFile, errors.New("a passphrase or a key file is required"))
+	}
+
+	return &fileStore{
+		backend: BackendEncryptedFile,
+		path:    path,
+		seal: func(b []byte) ([]byte, error) {
+			f := &encryptedFile{Version: encryptedFileVersion, Salt: make([]byte, saltSize)}
+			if _, err := io.ReadFull(rand.Reader, f.Salt); err != nil {
+				return nil, err
+			}
+			aead, err := newAEAD(secret, f.Salt)
+			if err != nil {
+				return nil, err
+			}
+			f.Nonce = make([]byte, aead.NonceSize())
+			if _, err = io.ReadFull(rand.Reader, f.Nonce); err != nil {
+				return nil, err
+			}
+			f.Ciphertext = aead.Seal(nil, f.Nonce, b, nil)
+			return json.Marshal(f)
+		},
+		open: func(b []byte) ([]byte, error) {
+			f := &encryptedFile{}
+			if err := json.Unmarshal(b, f); err != nil {
+				return nil, fmt.Errorf("the file is not a valid envelope (%w)", err)
+			}
+			if f.Version != encryptedFileVersion {
+				return nil, fmt.Errorf("unsupported envelope version %d", f.Version)
+			}
+			aead, err := newAEAD(secret, f.Salt)
+			if err != nil {
+				return nil, err
+			}
+			if len(f.Nonce) != aead.NonceSize() {
+				return nil, errors.New("invalid nonce size")
+			}
+			if b, err = aead.Open(nil, f.Nonce, f.Ciphertext, nil); err != nil {
+				return nil, errors.New("cannot decrypt the tokens, wrong passphrase or key file")
+			}
+			return b, nil
+		},
+	}, nil
+}
+
+func newAEAD(secret, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(secret, salt, scryptN, scryptR, scryptP, keySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
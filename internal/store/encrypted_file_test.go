@@ -0,0 +1,119 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptedFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.enc")
+	tokens := &Tokens{ID: "id-token", Refresh: "refresh-token", ClientSecret: "secret"}
+
+	s, err := NewEncryptedFileStore(path, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Save("default", tokens); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{tokens.ID, tokens.Refresh, tokens.ClientSecret} {
+		if bytes.Contains(b, []byte(secret)) {
+			t.Errorf("expected the file not to contain %q in clear", secret)
+		}
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != filePerm {
+		t.Errorf("expected the file mode %o, got %o", filePerm, info.Mode().Perm())
+	}
+
+	testCases := map[string]struct {
+		secret   string
+		expected *Tokens
+		failing  bool
+	}{
+		"same passphrase":  {secret: "passphrase", expected: tokens},
+		"wrong passphrase": {secret: "wrong", failing: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s, err := NewEncryptedFileStore(path, []byte(tc.secret))
+			if err != nil {
+				t.Fatal(err)
+			}
+			loaded, err := s.Load("default")
+			if tc.failing {
+				var storeErr *StoreError
+				if !errors.As(err, &storeErr) {
+					t.Errorf("expected a store error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *loaded != *tc.expected {
+				t.Errorf("expected %+v, got %+v", tc.expected, loaded)
+			}
+		})
+	}
+}
+
+func TestEncryptedFileStoreEmptySecret(t *testing.T) {
+	if _, err := NewEncryptedFileStore(filepath.Join(t.TempDir(), "tokens.enc"), nil); err == nil {
+		t.Errorf("expected an error without passphrase")
+	}
+}
+
+func TestEncryptedFileStoreTampered(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.enc")
+	s, err := NewEncryptedFileStore(path, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Save("default", &Tokens{ID: "id-token"}); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Flipping a character of the base64 encoded ciphertext
+	i := bytes.Index(b, []byte(`"ciphertext":"`)) + len(`"ciphertext":"`)
+	if b[i] == 'A' {
+		b[i] = 'B'
+	} else {
+		b[i] = 'A'
+	}
+	if err = ioutil.WriteFile(path, b, filePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = s.Load("default"); err == nil {
+		t.Errorf("expected the tampered file not to be decrypted")
+	}
+}
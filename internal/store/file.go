@@ -0,0 +1,181 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	BackendFile = "file"
+	// Tokens are readable by the owner only, whatever the backend file
+	filePerm = 0600
+	// Waiting at most for the concurrent writes of the other profiles
+	fileLockTimeout = 30 * time.Second
+)
+
+// fileStore keeps the tokens of all the profiles in a single file, sealed by the codec of the backend.
+type fileStore struct {
+	backend, path string
+	seal          func([]byte) ([]byte, error)
+	open          func([]byte) ([]byte, error)
+}
+
+// NewFileStore keeps the tokens in a plain JSON file readable by the owner only.
+func NewFileStore(path string) TokenStore {
+	plain := func(b []byte) ([]byte, error) { return b, nil }
+	return &fileStore{
+		backend: BackendFile,
+		path:    path,
+		seal:    plain,
+		open:    plain,
+	}
+}
+
+func (r fileStore) Load(profile string) (*Tokens, error) {
+	all, err := r.read()
+	if err != nil {
+		return nil, err
+	}
+	if tokens, ok := all[profile]; ok {
+		return tokens, nil
+	}
+	return &Tokens{}, nil
+}
+
+func (r fileStore) Save(profile string, tokens *Tokens) error {
+	unlock, err := r.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	all, err := r.read()
+	if err != nil {
+		return err
+	}
+	all[profile] = tokens
+	return r.write(all)
+}
+
+func (r fileStore) Delete(profile string) error {
+	unlock, err := r.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	all, err := r.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := all[profile]; !ok {
+		return nil
+	}
+	delete(all, profile)
+	return r.write(all)
+}
+
+// lock serializes the read-modify-write of the file, shared by all the profiles.
+func (r fileStore) lock() (func(), error) {
+	ctx, cancel := context.WithTimeout(context.Background(), fileLockTimeout)
+	defer cancel()
+
+	unlock, err := Lock(ctx, r.path+".lock")
+	if err != nil {
+		return nil, NewStoreError(r.backend, err)
+	}
+	return unlock, nil
+}
+
+func (r fileStore) read() (map[string]*Tokens, error) {
+	all := map[string]*Tokens{}
+
+	b, err := afero.ReadFile(afero.NewOsFs(), r.path)
+	if os.IsNotExist(err) {
+		return all, nil
+	}
+	if err != nil {
+		return nil, NewStoreError(r.backend, err)
+	}
+	if len(b) == 0 {
+		return all, nil
+	}
+	if b, err = r.open(b); err != nil {
+		return nil, NewStoreError(r.backend, err)
+	}
+	if err = json.Unmarshal(b, &all); err != nil {
+		return nil, NewStoreError(r.backend, err)
+	}
+	return all, nil
+}
+
+func (r fileStore) write(all map[string]*Tokens) error {
+	b, err := json.Marshal(all)
+	if err != nil {
+		return NewStoreError(r.backend, err)
+	}
+	if b, err = r.seal(b); err != nil {
+		return NewStoreError(r.backend, err)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(r.path), 0700); err != nil {
+		return NewStoreError(r.backend, err)
+	}
+	if err = writeFileAtomic(r.path, b, filePerm); err != nil {
+		return NewStoreError(r.backend, err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file with a temporary one renamed over it.
+func writeFileAtomic(path string, b []byte, perm os.FileMode) (err error) {
+	var f *os.File
+	if f, err = ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp-"); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	if err = f.Chmod(perm); err != nil {
+		_ = f.Close()
+		return
+	}
+	if _, err = f.Write(b); err != nil {
+		_ = f.Close()
+		return
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return
+	}
+	if err = f.Close(); err != nil {
+		return
+	}
+
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,69 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/zalando/go-keyring"
+)
+
+const BackendKeyring = "keyring"
+
+// keyringStore keeps the tokens in the OS keyring.
+type keyringStore struct {
+	service string
+}
+
+func NewKeyringStore(service string) TokenStore {
+	return &keyringStore{service: service}
+}
+
+func (r keyringStore) Load(profile string) (*Tokens, error) {
+	v, err := keyring.Get(r.service, profile)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return &Tokens{}, nil
+	}
+	if err != nil {
+		return nil, NewStoreError(BackendKeyring, err)
+	}
+
+	tokens := &Tokens{}
+	if err = json.Unmarshal([]byte(v), tokens); err != nil {
+		return nil, NewStoreError(BackendKeyring, err)
+	}
+	return tokens, nil
+}
+
+func (r keyringStore) Save(profile string, tokens *Tokens) error {
+	b, err := json.Marshal(tokens)
+	if err != nil {
+		return NewStoreError(BackendKeyring, err)
+	}
+	if err = keyring.Set(r.service, profile, string(b)); err != nil {
+		return NewStoreError(BackendKeyring, err)
+	}
+	return nil
+}
+
+func (r keyringStore) Delete(profile string) error {
+	if err := keyring.Delete(r.service, profile); err != nil && !errors.Is(err, keyring.ErrNotFound) {
+		return NewStoreError(BackendKeyring, err)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gofrs/flock"
+)
+
+const lockRetryDelay = 100 * time.Millisecond
+
+// Lock acquires the inter-process lock backed by the given file, returning the function releasing it.
+func Lock(ctx context.Context, path string) (unlock func(), err error) {
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("cannot create the lock directory (%w)", err)
+	}
+
+	l := flock.New(path)
+	var locked bool
+	if locked, err = l.TryLockContext(ctx, lockRetryDelay); err != nil {
+		return nil, fmt.Errorf("cannot acquire the lock %s (%w)", path, err)
+	}
+	if !locked {
+		return nil, fmt.Errorf("cannot acquire the lock %s", path)
+	}
+
+	return func() { _ = l.Unlock() }, nil
+}
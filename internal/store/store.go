@@ -0,0 +1,55 @@
+/*
+Copyright © 2021 Clastix Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"fmt"
+)
+
+// Tokens are the secrets of a profile: the ones issued by the OIDC server, along with the client secret.
+type Tokens struct {
+	ID           string `json:"id"`
+	Refresh      string `json:"refresh,omitempty"`
+	ClientSecret string `json:"clientSecret,omitempty"`
+}
+
+// TokenStore persists the tokens of the profiles out of the configuration file.
+type TokenStore interface {
+	// Load returns the tokens of the given profile, empty ones if never saved.
+	Load(profile string) (*Tokens, error)
+	// Save replaces the tokens of the given profile.
+	Save(profile string, tokens *Tokens) error
+	// Delete removes the tokens of the given profile, if any.
+	Delete(profile string) error
+}
+
+type StoreError struct {
+	backend string
+	error   error
+}
+
+func (r StoreError) Error() string {
+	return fmt.Sprintf("The %s token store failed: %s", r.backend, r.error.Error())
+}
+
+func (r StoreError) Unwrap() error {
+	return r.error
+}
+
+func NewStoreError(backend string, error error) error {
+	return &StoreError{backend: backend, error: error}
+}